@@ -7,17 +7,31 @@ import (
 	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"golang.org/x/net/proxy"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type ClientConfig struct {
+	URI                string
 	Host               string
 	Port               string
+	Hosts              []string
+	SocketPath         string
 	Username           string
 	Password           string
 	DB                 string
@@ -26,12 +40,61 @@ type ClientConfig struct {
 	ReplicaSet         string
 	RetryWrites        bool
 	Certificate        string
+	CertificateFile    string
+	TLSSystemRoots     bool
+	TLSMinVersion      string
+	TLSCipherSuites    []string
+	TLSServerName      string
+	ClientCertificate  string
+	ClientKey          string
+	AuthMechanism      string
 	Direct             bool
+	LoadBalanced       bool
 	Proxy              string
+	NoProxy            string
+	SSHTunnel          *SSHTunnelConfig
+	CredentialProcess  *CredentialProcess
+
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+	HeartbeatInterval      time.Duration
+
+	AuthMechanismProperties map[string]string
+
+	AppName          string
+	Compressors      []string
+	ReadPreference   string
+	WriteConcern     *WriteConcern
+	ServerAPIVersion string
+
+	tunnel              *sshTunnelDialer
+	credentialsExpireAt time.Time
+	connString          *connstring.ConnString
+	baseOptions         *options.ClientOptions
 }
+
+var authMechanisms = []string{"SCRAM-SHA-1", "SCRAM-SHA-256", "PLAIN", "GSSAPI", "MONGODB-X509"}
+
+// authenticationFailedCode is the server error code of a rejected authentication.
+const authenticationFailedCode = 18
+
+var scramMechanisms = []string{"SCRAM-SHA-1", "SCRAM-SHA-256"}
+
+var gssapiProperties = []string{"SERVICE_NAME", "CANONICALIZE_HOST_NAME", "SERVICE_REALM", "SERVICE_HOST"}
+
 type DbUser struct {
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	Name                       string                      `json:"name"`
+	Password                   string                      `json:"password"`
+	CustomData                 string                      `json:"customData"`
+	AuthenticationRestrictions []AuthenticationRestriction `json:"authenticationRestrictions"`
+	Mechanisms                 []string                    `json:"mechanisms"`
+	DigestPassword             bool                        `json:"digestPassword"`
+}
+
+type AuthenticationRestriction struct {
+	ClientSource  []string `json:"clientSource" bson:"clientSource,omitempty"`
+	ServerAddress []string `json:"serverAddress" bson:"serverAddress,omitempty"`
 }
 
 type Role struct {
@@ -62,6 +125,9 @@ type SingleResultGetUser struct {
 			Role string `json:"role"`
 			Db   string `json:"db"`
 		} `json:"roles"`
+		CustomData                 bson.Raw      `json:"customData"`
+		AuthenticationRestrictions bson.RawValue `json:"authenticationRestrictions"`
+		Mechanisms                 []string      `json:"mechanisms"`
 	} `json:"users"`
 }
 type SingleResultGetRole struct {
@@ -79,6 +145,7 @@ type SingleResultGetRole struct {
 			} `json:"resource"`
 			Actions []string `json:"actions"`
 		} `json:"privileges"`
+		AuthenticationRestrictions bson.RawValue `json:"authenticationRestrictions"`
 	} `json:"roles"`
 }
 
@@ -91,32 +158,82 @@ func addArgs(arguments string, newArg string) string {
 
 }
 
-func (c *ClientConfig) MongoClient() (*mongo.Client, error) {
+func (c *ClientConfig) MongoClient(ctx context.Context) (*mongo.Client, error) {
 
-	var verify = false
-	var arguments = ""
-
-	arguments = addArgs(arguments, "retrywrites="+strconv.FormatBool(c.RetryWrites))
+	clientOptions, err := c.clientOptions()
+	if err != nil {
+		return nil, err
+	}
 
-	if c.Ssl {
-		arguments = addArgs(arguments, "ssl=true")
+	username, password := c.Username, c.Password
+	/*
+		@Since: v0.1.0
+		credentials from an external command, renewed once they expire
+	*/
+	if c.CredentialProcess != nil {
+		credentials, expiresAt, err := c.CredentialProcess.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if credentials.Username != "" {
+			username = credentials.Username
+		}
+		password = credentials.Password
+		c.credentialsExpireAt = expiresAt
 	}
 
-	if c.ReplicaSet != "" && c.Direct == false {
-		arguments = addArgs(arguments, "replicaSet="+c.ReplicaSet)
+	fromURI, err := c.uriCredential()
+	if err != nil {
+		return nil, err
+	}
+	if credential, ok := c.credential(fromURI, username, password); ok {
+		clientOptions.SetAuth(credential)
 	}
 
-	if c.Direct {
-		arguments = addArgs(arguments, "connect="+"direct")
+	return mongo.NewClient(clientOptions)
+}
+
+// clientOptions returns a copy of the options of a client built from the provider configuration, except
+// the credentials. They are built once, ApplyURI looks the SRV and TXT records of a mongodb+srv uri up.
+func (c *ClientConfig) clientOptions() (*options.ClientOptions, error) {
+	if c.baseOptions == nil {
+		clientOptions, err := c.newClientOptions()
+		if err != nil {
+			return nil, err
+		}
+		c.baseOptions = clientOptions
 	}
+	return options.MergeClientOptions(c.baseOptions), nil
+}
+
+func (c *ClientConfig) newClientOptions() (*options.ClientOptions, error) {
 
-	var uri = "mongodb://" + c.Host + ":" + c.Port + arguments
+	var verify = false
+
+	clientOptions := options.Client().ApplyURI(c.connectionString())
+
+	/*
+		@Since: v0.1.0
+		options given in the uri win over the provider defaults
+	*/
+	c.mergeOptions(clientOptions)
 
 	dialer, dialerErr := proxyDialer(c)
 
 	if dialerErr != nil {
 		return nil, dialerErr
 	}
+	/*
+		@Since: v0.1.0
+		ssh bastion, reached through the proxy when one is set
+	*/
+	if c.SSHTunnel != nil {
+		if c.tunnel == nil {
+			c.tunnel = newSSHTunnelDialer(c.SSHTunnel, dialer)
+		}
+		dialer = c.tunnel
+	}
+	clientOptions.SetDialer(localSocketDialer{dialer})
 	/*
 		@Since: v0.0.9
 		verify certificate
@@ -128,25 +245,229 @@ func (c *ClientConfig) MongoClient() (*mongo.Client, error) {
 		@Since: v0.0.7
 		add certificate support for documentDB
 	*/
-	if c.Certificate != "" {
-		tlsConfig, err := getTLSConfigWithAllServerCertificates([]byte(c.Certificate), verify)
+	if c.Certificate != "" || c.CertificateFile != "" {
+		ca := []byte(c.Certificate)
+		if c.CertificateFile != "" {
+			content, err := ioutil.ReadFile(c.CertificateFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read certificate_file %s : %s", c.CertificateFile, err)
+			}
+			ca = content
+		}
+		tlsConfig, err := getTLSConfigWithAllServerCertificates(ca, verify, c.TLSSystemRoots)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	/*
+		@Since: v0.1.0
+		client certificate for mutual TLS and MONGODB-X509
+	*/
+	if c.ClientCertificate != "" {
+		certificate, err := getClientCertificate(c.ClientCertificate, c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := clientOptions.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{InsecureSkipVerify: verify}
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	/*
+		@Since: v0.1.0
+		tls hardening, applies whichever way tls was enabled
+	*/
+	if clientOptions.TLSConfig != nil {
+		c.hardenTLSConfig(clientOptions.TLSConfig)
+	}
+
+	return clientOptions, nil
+}
+
+func (c *ClientConfig) connectionString() string {
+	if c.URI != "" {
+		return c.URI
+	}
+
+	var arguments = ""
+
+	arguments = addArgs(arguments, "retrywrites="+strconv.FormatBool(c.RetryWrites))
+
+	if c.Ssl {
+		arguments = addArgs(arguments, "ssl=true")
+	}
+
+	if c.ReplicaSet != "" && c.Direct == false {
+		arguments = addArgs(arguments, "replicaSet="+c.ReplicaSet)
+	}
+
+	if c.Direct {
+		arguments = addArgs(arguments, "connect="+"direct")
+	}
+
+	if len(c.Hosts) > 0 {
+		return "mongodb://" + strings.Join(c.Hosts, ",") + arguments
+	}
+
+	if c.SocketPath != "" {
+		return "mongodb://" + url.QueryEscape(c.SocketPath) + arguments
+	}
+
+	return "mongodb://" + c.Host + ":" + c.Port + arguments
+}
+
+// mergeOptions applies the discrete provider options that the connection string does not set itself.
+func (c *ClientConfig) mergeOptions(clientOptions *options.ClientOptions) {
+	if clientOptions.RetryWrites == nil {
+		clientOptions.SetRetryWrites(c.RetryWrites)
+	}
+	if c.Ssl && clientOptions.TLSConfig == nil {
+		clientOptions.SetTLSConfig(new(tls.Config))
+	}
+	if c.ReplicaSet != "" && c.Direct == false && clientOptions.ReplicaSet == nil {
+		clientOptions.SetReplicaSet(c.ReplicaSet)
+	}
+	if c.Direct && clientOptions.Direct == nil {
+		clientOptions.SetDirect(true)
+	}
+	if c.LoadBalanced && clientOptions.LoadBalanced == nil {
+		clientOptions.SetLoadBalanced(true)
+	}
+	if c.ConnectTimeout > 0 && clientOptions.ConnectTimeout == nil {
+		clientOptions.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 && clientOptions.ServerSelectionTimeout == nil {
+		clientOptions.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.SocketTimeout > 0 && clientOptions.SocketTimeout == nil {
+		clientOptions.SetSocketTimeout(c.SocketTimeout)
+	}
+	if c.HeartbeatInterval > 0 && clientOptions.HeartbeatInterval == nil {
+		clientOptions.SetHeartbeatInterval(c.HeartbeatInterval)
+	}
+	if c.AppName != "" && clientOptions.AppName == nil {
+		clientOptions.SetAppName(c.AppName)
+	}
+	if len(c.Compressors) > 0 && clientOptions.Compressors == nil {
+		clientOptions.SetCompressors(c.Compressors)
+	}
+	if c.ReadPreference != "" && clientOptions.ReadPreference == nil {
+		mode, _ := readpref.ModeFromString(c.ReadPreference)
+		readPreference, _ := readpref.New(mode)
+		clientOptions.SetReadPreference(readPreference)
+	}
+	if c.WriteConcern != nil && clientOptions.WriteConcern == nil {
+		clientOptions.SetWriteConcern(c.WriteConcern.writeConcern())
+	}
+	if c.ServerAPIVersion != "" {
+		clientOptions.SetServerAPIOptions(options.ServerAPI(options.ServerAPIVersion(c.ServerAPIVersion)))
+	}
+}
+
+type WriteConcern struct {
+	W       string
+	Journal bool
+	Timeout time.Duration
+}
+
+func (w *WriteConcern) writeConcern() *writeconcern.WriteConcern {
+	var writeConcernOptions []writeconcern.Option
+	if number, err := strconv.Atoi(w.W); err == nil {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.W(number))
+	} else if w.W == "majority" {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.WMajority())
+	} else if w.W != "" {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.WTagSet(w.W))
+	}
+	if w.Journal {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.J(true))
+	}
+	if w.Timeout > 0 {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.WTimeout(w.Timeout))
+	}
+	return writeconcern.New(writeConcernOptions...)
+}
+
+// uriCredential returns the credential given in the uri. Unlike ApplyURI it keeps an authSource
+// given without username, e.g. in the TXT record of a mongodb+srv uri, so it applies to the provider username.
+func (c *ClientConfig) uriCredential() (*options.Credential, error) {
+	if c.URI == "" {
+		return nil, nil
+	}
+	cs, err := c.parseURI()
+	if err != nil {
+		return nil, err
+	}
+	return connStringCredential(*cs), nil
+}
+
+// parseURI parses the uri once, every parse of a mongodb+srv uri looks its SRV and TXT records up.
+func (c *ClientConfig) parseURI() (*connstring.ConnString, error) {
+	if c.connString == nil {
+		cs, err := connstring.ParseAndValidate(c.URI)
 		if err != nil {
 			return nil, err
 		}
-		mongoClient, err := mongo.NewClient(options.Client().ApplyURI(uri).SetAuth(options.Credential{
-			AuthSource: c.DB, Username: c.Username, Password: c.Password,
-		}).SetTLSConfig(tlsConfig).SetDialer(dialer))
+		c.connString = &cs
+	}
+	return c.connString, nil
+}
 
-		return mongoClient, err
+func connStringCredential(cs connstring.ConnString) *options.Credential {
+	return &options.Credential{
+		AuthMechanism:           cs.AuthMechanism,
+		AuthMechanismProperties: cs.AuthMechanismProperties,
+		AuthSource:              cs.AuthSource,
+		Username:                cs.Username,
+		Password:                cs.Password,
+		PasswordSet:             cs.PasswordSet,
 	}
+}
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(uri).SetAuth(options.Credential{
-		AuthSource: c.DB, Username: c.Username, Password: c.Password,
-	}).SetDialer(dialer))
-	return client, err
+// credential merges the credential parsed from the uri with the provider username, password
+// and auth database. Every non-empty provider field overrides its counterpart from the uri.
+func (c *ClientConfig) credential(fromURI *options.Credential, username string, password string) (options.Credential, bool) {
+	var credential options.Credential
+	if fromURI != nil {
+		credential = *fromURI
+	}
+	if username != "" {
+		credential.Username = username
+	}
+	if password != "" {
+		credential.Password = password
+		credential.PasswordSet = true
+	}
+	if c.DB != "" {
+		credential.AuthSource = c.DB
+	}
+	if c.AuthMechanism != "" {
+		credential.AuthMechanism = c.AuthMechanism
+	}
+	if len(c.AuthMechanismProperties) > 0 {
+		credential.AuthMechanismProperties = c.AuthMechanismProperties
+	}
+	if credential.AuthMechanism == "MONGODB-X509" {
+		// the user is the certificate subject, it lives in $external and has no password
+		credential.AuthSource = "$external"
+		credential.Password = ""
+		credential.PasswordSet = false
+		return credential, true
+	}
+	if credential.AuthSource == "" {
+		credential.AuthSource = "admin"
+		if credential.AuthMechanism == "PLAIN" || credential.AuthMechanism == "GSSAPI" {
+			// the users of external mechanisms live in $external
+			credential.AuthSource = "$external"
+		}
+	}
+	return credential, credential.Username != ""
 }
 
-func getTLSConfigWithAllServerCertificates(ca []byte, verify bool) (*tls.Config, error) {
+func getTLSConfigWithAllServerCertificates(ca []byte, verify bool, systemRoots bool) (*tls.Config, error) {
 	/* As of version 1.2.1, the MongoDB Go Driver will only use the first CA server certificate found in sslcertificateauthorityfile.
 	   The code below addresses this limitation by manually appending all server certificates found in sslcertificateauthorityfile
 	   to a custom TLS configuration used during client creation. */
@@ -155,6 +476,13 @@ func getTLSConfigWithAllServerCertificates(ca []byte, verify bool) (*tls.Config,
 
 	tlsConfig.InsecureSkipVerify = verify
 	tlsConfig.RootCAs = x509.NewCertPool()
+	if systemRoots {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return tlsConfig, fmt.Errorf("could not load the system certificate pool : %s", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
 	ok := tlsConfig.RootCAs.AppendCertsFromPEM(ca)
 
 	if !ok {
@@ -164,6 +492,180 @@ func getTLSConfigWithAllServerCertificates(ca []byte, verify bool) (*tls.Config,
 	return tlsConfig, nil
 }
 
+// validateAuth checks the combinations of authentication settings the server would reject. It checks
+// the credential the client would use, the one of the uri merged with the provider settings.
+func (c *ClientConfig) validateAuth() error {
+	var fromURI *options.Credential
+	var uriClientCertificate bool
+	if c.URI != "" {
+		cs, err := c.parseURI()
+		if err != nil {
+			return err
+		}
+		fromURI = connStringCredential(*cs)
+		uriClientCertificate = cs.SSLClientCertificateKeyFileSet
+	}
+	credential, _ := c.credential(fromURI, c.Username, c.Password)
+
+	switch credential.AuthMechanism {
+	case "MONGODB-X509":
+		if c.ClientCertificate == "" && !uriClientCertificate {
+			return errors.New("auth_mechanism MONGODB-X509 requires a client_certificate")
+		}
+		if c.Password != "" || (fromURI != nil && fromURI.PasswordSet) {
+			return errors.New("password can not be used with auth_mechanism MONGODB-X509")
+		}
+	case "PLAIN":
+		if credential.AuthSource != "$external" {
+			return errors.New("auth_mechanism PLAIN requires auth_database = \"$external\"")
+		}
+	case "GSSAPI":
+		if credential.AuthSource != "$external" {
+			return errors.New("auth_mechanism GSSAPI requires auth_database = \"$external\"")
+		}
+	case "SCRAM-SHA-1", "SCRAM-SHA-256":
+		if credential.AuthSource == "$external" {
+			return fmt.Errorf("auth_mechanism %s can not be used with auth_database = \"$external\"", credential.AuthMechanism)
+		}
+	}
+
+	if len(credential.AuthMechanismProperties) > 0 {
+		if credential.AuthMechanism != "GSSAPI" {
+			return errors.New("auth_mechanism_properties can only be used with auth_mechanism GSSAPI")
+		}
+		for key := range credential.AuthMechanismProperties {
+			if !contains(gssapiProperties, key) {
+				return fmt.Errorf("unsupported auth_mechanism_properties key %s, expected one of %s", key, strings.Join(gssapiProperties, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func tlsVersionNames() []string {
+	names := make([]string, 0, len(tlsVersions))
+	for name := range tlsVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func tlsCipherSuiteNames() []string {
+	var names []string
+	for _, suite := range tls.CipherSuites() {
+		names = append(names, suite.Name)
+	}
+	return names
+}
+
+func tlsCipherSuiteID(name string) uint16 {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID
+		}
+	}
+	return 0
+}
+
+// validateTLS checks that the tls options are not set without anything enabling tls.
+func (c *ClientConfig) validateTLS() error {
+	if c.Certificate != "" && c.CertificateFile != "" {
+		return errors.New("certificate and certificate_file can not be used together")
+	}
+	tlsEnabled := c.URI != "" || c.Ssl || c.Certificate != "" || c.CertificateFile != "" || c.ClientCertificate != ""
+	if !tlsEnabled && (c.TLSMinVersion != "" || len(c.TLSCipherSuites) > 0 || c.TLSServerName != "" || c.TLSSystemRoots) {
+		return errors.New("tls_min_version, tls_cipher_suites, tls_server_name and tls_append_system_roots require ssl to be enabled")
+	}
+	return nil
+}
+
+// validateLoadBalanced rejects load balanced mode, enabled by load_balanced or by loadBalanced=true in
+// the uri, together with a replica set, a direct connection or several hosts.
+func (c *ClientConfig) validateLoadBalanced() error {
+	loadBalanced, replicaSet, direct, hosts := c.LoadBalanced, c.ReplicaSet, c.Direct, c.Hosts
+	if c.URI != "" {
+		// options given in the uri win over the provider ones, as in mergeOptions
+		cs, err := c.parseURI()
+		if err != nil {
+			return err
+		}
+		if cs.LoadBalancedSet {
+			loadBalanced = cs.LoadBalanced
+		}
+		if cs.ReplicaSet != "" {
+			replicaSet = cs.ReplicaSet
+		}
+		if cs.DirectConnectionSet || cs.ConnectSet {
+			direct = cs.DirectConnection || cs.Connect == connstring.SingleConnect
+		}
+		hosts = cs.Hosts
+	}
+
+	if !loadBalanced {
+		return nil
+	}
+	if replicaSet != "" {
+		return errors.New("load_balanced can not be enabled with replica_set")
+	}
+	if direct {
+		return errors.New("load_balanced can not be enabled with direct")
+	}
+	if len(hosts) > 1 {
+		return errors.New("load_balanced can not be enabled with more than one host in hosts")
+	}
+	return nil
+}
+
+func (c *ClientConfig) hardenTLSConfig(tlsConfig *tls.Config) {
+	if c.TLSMinVersion != "" {
+		tlsConfig.MinVersion = tlsVersions[c.TLSMinVersion]
+	}
+	if len(c.TLSCipherSuites) > 0 {
+		tlsConfig.CipherSuites = nil
+		for _, name := range c.TLSCipherSuites {
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, tlsCipherSuiteID(name))
+		}
+	}
+	if c.TLSServerName != "" {
+		tlsConfig.ServerName = c.TLSServerName
+	}
+}
+
+func getClientCertificate(certificate string, key string) (tls.Certificate, error) {
+	certificatePEM, err := pemContent(certificate)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM := certificatePEM
+	if key != "" {
+		keyPEM, err = pemContent(key)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.X509KeyPair(certificatePEM, keyPEM)
+}
+
+// pemContent returns value when it already holds PEM data, otherwise it reads the file value points to.
+func pemContent(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	content, err := ioutil.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("could not read pem file %s : %s", value, err)
+	}
+	return content, nil
+}
+
 func (privilege Privilege) String() string {
 	return fmt.Sprintf("{ resource : %s , actions : %s }", privilege.Resource, privilege.Actions)
 }
@@ -177,15 +679,74 @@ func (resource Resource) String() string {
 	return fmt.Sprintf(" { db : %s , collection : %s }", resource.Db, resource.Collection)
 }
 
-func createUser(client *mongo.Client, user DbUser, roles []Role, database string) error {
-	var result *mongo.SingleResult
-	if len(roles) != 0 {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createUser", Value: user.Name},
-			{Key: "pwd", Value: user.Password}, {Key: "roles", Value: roles}})
-	} else {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createUser", Value: user.Name},
-			{Key: "pwd", Value: user.Password}, {Key: "roles", Value: []bson.M{}}})
+func createUser(ctx context.Context, client *mongo.Client, user DbUser, roles []Role, database string) error {
+	var rolesValue interface{} = roles
+	if len(roles) == 0 {
+		rolesValue = []bson.M{}
+	}
+	command := bson.D{{Key: "createUser", Value: user.Name}}
+	if user.Password != "" {
+		command = append(command, bson.E{Key: "pwd", Value: user.Password})
+	}
+	command = append(command, bson.E{Key: "roles", Value: rolesValue})
+	if user.CustomData != "" {
+		customData, err := customDataDocument(user.CustomData)
+		if err != nil {
+			return err
+		}
+		command = append(command, bson.E{Key: "customData", Value: customData})
+	}
+	if len(user.AuthenticationRestrictions) != 0 {
+		command = append(command, bson.E{Key: "authenticationRestrictions", Value: user.AuthenticationRestrictions})
+	}
+	if len(user.Mechanisms) != 0 {
+		command = append(command, bson.E{Key: "mechanisms", Value: user.Mechanisms})
+	}
+	if !user.DigestPassword {
+		command = append(command, bson.E{Key: "digestPassword", Value: false})
+	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, command))
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+// validatePasswordDigest checks a pre-digested password is only used with SCRAM-SHA-1, the server
+// digests SCRAM-SHA-256 passwords itself.
+func validatePasswordDigest(digestPassword bool, mechanisms []string) error {
+	if digestPassword {
+		return nil
+	}
+	if len(mechanisms) != 1 || mechanisms[0] != "SCRAM-SHA-1" {
+		return fmt.Errorf("digest_password = false requires mechanisms = [\"SCRAM-SHA-1\"]")
+	}
+	return nil
+}
+
+// validateUserPassword checks password users have a password and users of $external have none.
+func validateUserPassword(database string, name string, password string) error {
+	if database != externalDatabase {
+		if password == "" {
+			return fmt.Errorf("password is required for the users of %s", database)
+		}
+		return nil
+	}
+	if password != "" {
+		return fmt.Errorf("the users of %s authenticate with X.509 certificates or LDAP and cannot have a password", externalDatabase)
+	}
+	return validateExternalUserName(name)
+}
+
+// updateUser applies the given fields of the user in place.
+func updateUser(ctx context.Context, client *mongo.Client, username string, changes bson.D, database string) error {
+	if len(changes) == 0 {
+		return nil
 	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, append(bson.D{{Key: "updateUser", Value: username}}, changes...)))
 
 	if result.Err() != nil {
 		return result.Err()
@@ -193,13 +754,107 @@ func createUser(client *mongo.Client, user DbUser, roles []Role, database string
 	return nil
 }
 
-func getUser(client *mongo.Client, username string, database string) (SingleResultGetUser, error) {
+// decodeAuthenticationRestrictions reads the authenticationRestrictions of usersInfo and rolesInfo,
+// the server returns either a list of restrictions or a list of lists of restrictions.
+func decodeAuthenticationRestrictions(value bson.RawValue) ([]AuthenticationRestriction, error) {
+	var restrictions []AuthenticationRestriction
+	if value.Type != bsontype.Array {
+		return restrictions, nil
+	}
+	elements, err := value.Array().Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range elements {
+		if element.Type == bsontype.Array {
+			nested, err := decodeAuthenticationRestrictions(element)
+			if err != nil {
+				return nil, err
+			}
+			restrictions = append(restrictions, nested...)
+			continue
+		}
+		var restriction AuthenticationRestriction
+		if err := element.Unmarshal(&restriction); err != nil {
+			return nil, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions, nil
+}
+
+// customDataDocument parses the custom_data attribute, written in relaxed extended JSON.
+func customDataDocument(customData string) (bson.D, error) {
+	document := bson.D{}
+	if customData == "" {
+		return document, nil
+	}
+	err := bson.UnmarshalExtJSON([]byte(customData), false, &document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom_data : %s", err)
+	}
+	return document, nil
+}
+
+// customDataString renders the customData of a user the way custom_data is stored in state.
+func customDataString(customData bson.Raw) (string, error) {
+	if len(customData) == 0 {
+		return "", nil
+	}
+	extJSON, err := bson.MarshalExtJSON(customData, false, false)
+	if err != nil {
+		return "", err
+	}
+	return normalizeCustomData(string(extJSON)), nil
+}
+
+// normalizeCustomData is the custom_data stored in state, the server keeps no customData for an empty document.
+func normalizeCustomData(customData string) string {
+	normalized := normalizeJSON(customData)
+	if normalized == "{}" {
+		return ""
+	}
+	return normalized
+}
+
+func grantRolesToUser(ctx context.Context, client *mongo.Client, username string, roles []Role, database string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "grantRolesToUser", Value: username},
+		{Key: "roles", Value: roles}}))
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+func revokeRolesFromUser(ctx context.Context, client *mongo.Client, username string, roles []Role, database string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "revokeRolesFromUser", Value: username},
+		{Key: "roles", Value: roles}}))
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+func getUser(ctx context.Context, client *mongo.Client, username string, database string, fromPrimary bool) (SingleResultGetUser, error) {
 	var result *mongo.SingleResult
-	result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "usersInfo", Value: bson.D{
+	db := client.Database(database)
+	result = db.RunCommand(ctx, bson.D{{Key: "usersInfo", Value: bson.D{
 		{Key: "user", Value: username},
 		{Key: "db", Value: database},
 	},
-	}})
+	},
+		{Key: "showAuthenticationRestrictions", Value: true},
+	}, readCommandOptions(db, fromPrimary))
 	var decodedResult SingleResultGetUser
 	err := result.Decode(&decodedResult)
 	if err != nil {
@@ -208,15 +863,17 @@ func getUser(client *mongo.Client, username string, database string) (SingleResu
 	return decodedResult, nil
 }
 
-func getRole(client *mongo.Client, roleName string, database string) (SingleResultGetRole, error) {
+func getRole(ctx context.Context, client *mongo.Client, roleName string, database string, fromPrimary bool) (SingleResultGetRole, error) {
 	var result *mongo.SingleResult
-	result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "rolesInfo", Value: bson.D{
+	db := client.Database(database)
+	result = db.RunCommand(ctx, bson.D{{Key: "rolesInfo", Value: bson.D{
 		{Key: "role", Value: roleName},
 		{Key: "db", Value: database},
 	},
 	},
 		{Key: "showPrivileges", Value: true},
-	})
+		{Key: "showAuthenticationRestrictions", Value: true},
+	}, readCommandOptions(db, fromPrimary))
 	var decodedResult SingleResultGetRole
 	err := result.Decode(&decodedResult)
 	if err != nil {
@@ -225,9 +882,33 @@ func getRole(client *mongo.Client, roleName string, database string) (SingleResu
 	return decodedResult, nil
 }
 
-func createRole(client *mongo.Client, role string, roles []Role, privilege []PrivilegeDto, database string) error {
-	var privileges []Privilege
+func createRole(ctx context.Context, client *mongo.Client, role string, roles []Role, privilege []PrivilegeDto, restrictions []AuthenticationRestriction, database string) error {
+	var privileges = toPrivileges(privilege)
 	var result *mongo.SingleResult
+	var rolesValue interface{} = roles
+	if len(roles) == 0 {
+		rolesValue = []bson.M{}
+	}
+	var privilegesValue interface{} = privileges
+	if len(privileges) == 0 {
+		privilegesValue = []bson.M{}
+	}
+	var command = bson.D{{Key: "createRole", Value: role},
+		{Key: "privileges", Value: privilegesValue}, {Key: "roles", Value: rolesValue}}
+	if len(restrictions) != 0 {
+		command = append(command, bson.E{Key: "authenticationRestrictions", Value: restrictions})
+	}
+	db := client.Database(database)
+	result = db.RunCommand(ctx, withWriteConcern(db, command))
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+func toPrivileges(privilege []PrivilegeDto) []Privilege {
+	var privileges []Privilege
 	for _, element := range privilege {
 		var prv Privilege
 		prv.Resource = Resource{
@@ -237,19 +918,17 @@ func createRole(client *mongo.Client, role string, roles []Role, privilege []Pri
 		prv.Actions = element.Actions
 		privileges = append(privileges, prv)
 	}
-	if len(roles) != 0 && len(privileges) != 0 {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createRole", Value: role},
-			{Key: "privileges", Value: privileges}, {Key: "roles", Value: roles}})
-	} else if len(roles) == 0 && len(privileges) != 0 {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createRole", Value: role},
-			{Key: "privileges", Value: privileges}, {Key: "roles", Value: []bson.M{}}})
-	} else if len(roles) != 0 && len(privileges) == 0 {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createRole", Value: role},
-			{Key: "privileges", Value: []bson.M{}}, {Key: "roles", Value: roles}})
-	} else {
-		result = client.Database(database).RunCommand(context.Background(), bson.D{{Key: "createRole", Value: role},
-			{Key: "privileges", Value: []bson.M{}}, {Key: "roles", Value: []bson.M{}}})
+	return privileges
+}
+
+func updateRolePrivileges(ctx context.Context, client *mongo.Client, role string, privilege []PrivilegeDto, database string) error {
+	var privilegesValue interface{} = toPrivileges(privilege)
+	if len(privilege) == 0 {
+		privilegesValue = []bson.M{}
 	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "updateRole", Value: role},
+		{Key: "privileges", Value: privilegesValue}}))
 
 	if result.Err() != nil {
 		return result.Err()
@@ -257,41 +936,246 @@ func createRole(client *mongo.Client, role string, roles []Role, privilege []Pri
 	return nil
 }
 
-func MongoClientInit(conf *MongoDatabaseConfiguration) (*mongo.Client, error) {
+func updateRoleInheritedRoles(ctx context.Context, client *mongo.Client, role string, roles []Role, database string) error {
+	var rolesValue interface{} = roles
+	if len(roles) == 0 {
+		rolesValue = []bson.M{}
+	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "updateRole", Value: role},
+		{Key: "roles", Value: rolesValue}}))
 
-	client, err := conf.Config.MongoClient()
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+func updateRoleAuthenticationRestrictions(ctx context.Context, client *mongo.Client, role string, restrictions []AuthenticationRestriction, database string) error {
+	var restrictionsValue interface{} = restrictions
+	if len(restrictions) == 0 {
+		restrictionsValue = []bson.M{}
+	}
+	db := client.Database(database)
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "updateRole", Value: role},
+		{Key: "authenticationRestrictions", Value: restrictionsValue}}))
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+	return nil
+}
+
+// withWriteConcern appends the provider write concern to an administration command, RunCommand does not send it itself.
+func withWriteConcern(db *mongo.Database, command bson.D) bson.D {
+	wc := db.WriteConcern()
+	if wc == nil {
+		return command
+	}
+	valueType, value, err := wc.MarshalBSONValue()
 	if err != nil {
-		return nil, err
+		return command
+	}
+	return append(command, bson.E{Key: "writeConcern", Value: bson.RawValue{Type: valueType, Value: value}})
+}
+
+// readCommandOptions runs the info commands with the provider read preference, or on the primary to read back
+// a write a lagging secondary may not have applied yet. RunCommand defaults to the primary.
+func readCommandOptions(db *mongo.Database, fromPrimary bool) *options.RunCmdOptions {
+	runCmdOptions := options.RunCmd()
+	if fromPrimary {
+		return runCmdOptions.SetReadPreference(readpref.Primary())
+	}
+	if rp := db.ReadPreference(); rp != nil {
+		runCmdOptions.SetReadPreference(rp)
+	}
+	return runCmdOptions
+}
+
+// configurations holds every configured provider instance so their clients can be disconnected when the plugin stops.
+var (
+	configurationsLock sync.Mutex
+	configurations     []*MongoDatabaseConfiguration
+)
+
+func registerConfiguration(conf *MongoDatabaseConfiguration) {
+	configurationsLock.Lock()
+	defer configurationsLock.Unlock()
+	configurations = append(configurations, conf)
+}
+
+// MongoClientInit returns the client shared by every resource of the provider instance,
+// connecting it on first use. A failed connection is not cached so the next call retries.
+func MongoClientInit(ctx context.Context, conf *MongoDatabaseConfiguration) (*mongo.Client, error) {
+	conf.clientLock.Lock()
+	defer conf.clientLock.Unlock()
+
+	if conf.client != nil && !conf.Config.credentialsExpired() {
+		return conf.client, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), conf.MaxConnLifetime*time.Second)
-	defer cancel()
-	err = client.Connect(ctx)
+	if conf.client != nil {
+		// other resources may still run commands on it, it is disconnected with the new one
+		conf.retiredClients = append(conf.retiredClients, conf.client)
+		conf.client = nil
+	}
+
+	client, err := conf.Config.MongoClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = client.Ping(ctx, nil)
+	err = conf.connect(ctx, client)
 	if err != nil {
+		_ = client.Disconnect(ctx)
+		conf.Config.closeTunnel()
 		return nil, err
 	}
+	conf.client = client
 	return client, nil
 }
 
+// connect connects client and pings it. The connection is bounded by connect_timeout and the ping,
+// which first waits for a server to be selected, by server_selection_timeout.
+func (conf *MongoDatabaseConfiguration) connect(ctx context.Context, client *mongo.Client) error {
+	connectCtx := ctx
+	if conf.MaxConnLifetime > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, conf.MaxConnLifetime)
+		defer cancel()
+	}
+	if err := client.Connect(connectCtx); err != nil {
+		return err
+	}
+
+	pingCtx := ctx
+	if conf.Config.ServerSelectionTimeout > 0 {
+		var cancel context.CancelFunc
+		pingCtx, cancel = context.WithTimeout(ctx, conf.Config.ServerSelectionTimeout)
+		defer cancel()
+	}
+	return client.Ping(pingCtx, nil)
+}
+
+// verifyUserPassword makes a SCRAM authentication attempt as the given user on a short-lived client
+// built from the provider configuration, it returns false when the server rejects the password.
+func (conf *MongoDatabaseConfiguration) verifyUserPassword(ctx context.Context, username string, password string, database string) (bool, error) {
+	conf.clientLock.Lock()
+	clientOptions, err := conf.Config.clientOptions()
+	conf.clientLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+	clientOptions.SetAuth(options.Credential{
+		Username:    username,
+		Password:    password,
+		PasswordSet: true,
+		AuthSource:  database,
+	})
+	clientOptions.SetMaxPoolSize(1)
+
+	client, err := mongo.NewClient(clientOptions)
+	if err != nil {
+		return false, err
+	}
+	err = conf.connect(ctx, client)
+	defer func() { _ = client.Disconnect(ctx) }()
+	if err == nil {
+		return true, nil
+	}
+	var commandErr driver.Error
+	if errors.As(err, &commandErr) && commandErr.Code == authenticationFailedCode {
+		return false, nil
+	}
+	return false, err
+}
+
+func (c *ClientConfig) closeTunnel() {
+	if c.tunnel != nil {
+		_ = c.tunnel.Close()
+		c.tunnel = nil
+	}
+}
+
+// Disconnect closes the shared client, if it was ever connected.
+func (conf *MongoDatabaseConfiguration) Disconnect(ctx context.Context) error {
+	conf.clientLock.Lock()
+	defer conf.clientLock.Unlock()
+
+	var err error
+	for _, client := range conf.retiredClients {
+		_ = client.Disconnect(ctx)
+	}
+	conf.retiredClients = nil
+	if conf.client != nil {
+		err = conf.client.Disconnect(ctx)
+		conf.client = nil
+	}
+	conf.Config.closeTunnel()
+	return err
+}
+
+// DisconnectClients closes the shared clients of every provider instance, it is called once the plugin stops serving.
+func DisconnectClients() {
+	configurationsLock.Lock()
+	defer configurationsLock.Unlock()
+
+	for _, conf := range configurations {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.MaxConnLifetime)
+		if err := conf.Disconnect(ctx); err != nil {
+			log.Printf("[WARN] Error disconnecting from database : %s", err)
+		}
+		cancel()
+	}
+	configurations = nil
+}
+
+// localSocketDialer dials unix domain sockets directly, they are local and never go through the proxy or ssh tunnel.
+type localSocketDialer struct {
+	options.ContextDialer
+}
+
+func (d localSocketDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "unix" {
+		return proxy.Direct.DialContext(ctx, network, address)
+	}
+	return d.ContextDialer.DialContext(ctx, network, address)
+}
+
 func proxyDialer(c *ClientConfig) (options.ContextDialer, error) {
-	proxyFromEnv := proxy.FromEnvironment().(options.ContextDialer)
 	proxyFromProvider := c.Proxy
 
-	if len(proxyFromProvider) > 0 {
-		proxyURL, err := url.Parse(proxyFromProvider)
-		if err != nil {
-			return nil, err
-		}
-		proxyDialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	if len(proxyFromProvider) == 0 {
+		return proxy.Direct, nil
+	}
+
+	proxyURL, err := url.Parse(proxyFromProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxyDialer proxy.Dialer
+	switch proxyURL.Scheme {
+	case "http", "https":
+		proxyDialer = newHTTPConnectDialer(proxyURL, proxy.Direct)
+	default:
+		proxyDialer, err = proxy.FromURL(proxyURL, proxy.Direct)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		return proxyDialer.(options.ContextDialer), nil
+	/*
+		@Since: v0.1.0
+		hosts matched by no_proxy bypass the proxy
+	*/
+	if c.NoProxy != "" {
+		perHost := proxy.NewPerHost(proxyDialer, proxy.Direct)
+		perHost.AddFromString(c.NoProxy)
+		proxyDialer = perHost
 	}
 
-	return proxyFromEnv, nil
+	contextDialer, ok := proxyDialer.(options.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("the proxy %s does not support dialing with a context", proxyURL.Redacted())
+	}
+	return contextDialer, nil
 }
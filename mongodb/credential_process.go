@@ -0,0 +1,66 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// credentialRefreshMargin renews the credentials a little before they expire so no command runs with stale ones.
+const credentialRefreshMargin = 30 * time.Second
+
+type CredentialProcess struct {
+	Command []string
+	Timeout time.Duration
+}
+
+type processCredentials struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// fetch runs the command and decodes the credentials it prints on stdout, the command is killed when ctx
+// is cancelled or after the timeout.
+func (p *CredentialProcess) fetch(ctx context.Context) (processCredentials, time.Time, error) {
+	var credentials processCredentials
+	var expiresAt time.Time
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return credentials, expiresAt, fmt.Errorf("credential_process %s failed : %s %s", p.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return credentials, expiresAt, fmt.Errorf("credential_process %s returned invalid json : %s", p.Command[0], err)
+	}
+	if credentials.Password == "" {
+		return credentials, expiresAt, fmt.Errorf("credential_process %s returned no password", p.Command[0])
+	}
+	if credentials.ExpiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, credentials.ExpiresAt)
+		if err != nil {
+			return credentials, expiresAt, fmt.Errorf("credential_process %s returned an invalid expires_at : %s", p.Command[0], err)
+		}
+		expiresAt = parsed
+	}
+	return credentials, expiresAt, nil
+}
+
+// credentialsExpired reports whether the credentials returned by the credential process must be renewed.
+func (c *ClientConfig) credentialsExpired() bool {
+	if c.credentialsExpireAt.IsZero() {
+		return false
+	}
+	return time.Now().Add(credentialRefreshMargin).After(c.credentialsExpireAt)
+}
@@ -0,0 +1,157 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// credentialScript writes a shell script running body and returns the credential_process command.
+func credentialScript(t *testing.T, body string) []string {
+	path := filepath.Join(t.TempDir(), "credentials.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return []string{path}
+}
+
+func printCredentials(output string) string {
+	return "cat <<'EOF'\n" + output + "\nEOF"
+}
+
+func TestProviderConfigurePasswordFile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := configureProvider(t, map[string]interface{}{"host": "localhost", "username": "admin", "password_file": passwordFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "secret" {
+		t.Errorf("expected the trailing newline to be trimmed, got %q", config.Password)
+	}
+
+	_, err = configureProvider(t, map[string]interface{}{"host": "localhost", "username": "admin", "password_file": passwordFile + ".missing"})
+	if err == nil || !strings.Contains(err.Error(), "password_file") {
+		t.Errorf("expected a missing password_file to be reported, got %v", err)
+	}
+}
+
+func TestCredentialProcessFetch(t *testing.T) {
+	cases := []struct {
+		name      string
+		script    string
+		expected  processCredentials
+		expiresAt time.Time
+		err       string
+	}{
+		{
+			name:      "full",
+			script:    printCredentials(`{"username": "vault-user", "password": "secret", "expires_at": "2030-01-02T15:04:05Z"}`),
+			expected:  processCredentials{Username: "vault-user", Password: "secret", ExpiresAt: "2030-01-02T15:04:05Z"},
+			expiresAt: time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			name:     "password only",
+			script:   printCredentials(`{"password": "secret"}`),
+			expected: processCredentials{Password: "secret"},
+		},
+		{name: "invalid json", script: printCredentials("password=secret"), err: "invalid json"},
+		{name: "no password", script: printCredentials(`{"username": "vault-user"}`), err: "no password"},
+		{name: "invalid expires_at", script: printCredentials(`{"password": "secret", "expires_at": "tomorrow"}`), err: "invalid expires_at"},
+		{name: "failure", script: "echo vault is sealed >&2\nexit 2", err: "vault is sealed"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			process := &CredentialProcess{Command: credentialScript(t, c.script), Timeout: 10 * time.Second}
+			credentials, expiresAt, err := process.fetch(context.Background())
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected an error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credentials != c.expected || !expiresAt.Equal(c.expiresAt) {
+				t.Errorf("fetch() = %+v, %s", credentials, expiresAt)
+			}
+		})
+	}
+}
+
+func TestCredentialProcessHonoursContext(t *testing.T) {
+	process := &CredentialProcess{Command: credentialScript(t, "exec sleep 10"), Timeout: 10 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := process.fetch(ctx); err == nil {
+		t.Fatal("expected a cancelled credential_process to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed at the context deadline, took %s", elapsed)
+	}
+}
+
+func TestCredentialProcessRefetchOnExpiry(t *testing.T) {
+	var lock sync.Mutex
+	var payloads []string
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		if command != "saslStart" {
+			return nil, false
+		}
+		_, payload, _ := body.Lookup("payload").BinaryOK()
+		lock.Lock()
+		payloads = append(payloads, string(payload))
+		lock.Unlock()
+		return bson.D{{Key: "conversationId", Value: 1}, {Key: "done", Value: true}, {Key: "payload", Value: []byte{}}, {Key: "ok", Value: 1}}, true
+	})
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	config := fakeClientConfig(server)
+	config.AuthMechanism = "PLAIN"
+	config.CredentialProcess = &CredentialProcess{
+		Command: credentialScript(t, "echo run >> "+runs+"\n"+printCredentials(`{"username": "vault-user", "password": "secret", "expires_at": "2000-01-01T00:00:00Z"}`)),
+		Timeout: 10 * time.Second,
+	}
+	conf := &MongoDatabaseConfiguration{Config: config, MaxConnLifetime: 10 * time.Second}
+	defer conf.Disconnect(context.Background())
+
+	first, err := MongoClientInit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MongoClientInit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("expected expired credentials to build a new client")
+	}
+
+	content, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(content), "run"); count != 2 {
+		t.Errorf("expected credential_process to run again once the credentials expired, ran %d times", count)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(payloads) == 0 {
+		t.Error("expected the client to authenticate")
+	}
+	for _, payload := range payloads {
+		if payload != "\x00vault-user\x00secret" {
+			t.Errorf("expected the client to authenticate with the fetched credentials, got %q", payload)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package mongodb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Users of $external are authenticated by X.509 certificates or LDAP, the name of an X.509 user is the
+// RFC 4514 subject of its certificate, e.g. CN=client,OU=clients,O=example.
+
+const externalDatabase = "$external"
+
+var attributeTypePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*|[0-9]+(\.[0-9]+)*)$`)
+
+// validateExternalUserName checks the names looking like a distinguished name are valid ones,
+// other names are LDAP user names and accepted as they are.
+func validateExternalUserName(name string) error {
+	if !strings.Contains(name, "=") {
+		return nil
+	}
+	return validateDistinguishedName(name)
+}
+
+func validateDistinguishedName(name string) error {
+	rdns, err := splitUnescaped(name, ',')
+	if err != nil {
+		return fmt.Errorf("invalid distinguished name (%s) : %s", name, err)
+	}
+	for _, rdn := range rdns {
+		attributes, err := splitUnescaped(rdn, '+')
+		if err != nil {
+			return fmt.Errorf("invalid distinguished name (%s) : %s", name, err)
+		}
+		for _, attribute := range attributes {
+			separator := strings.Index(attribute, "=")
+			if separator < 0 {
+				return fmt.Errorf("invalid distinguished name (%s) : expected type=value, got %q", name, attribute)
+			}
+			attributeType := strings.TrimSpace(attribute[:separator])
+			if !attributeTypePattern.MatchString(attributeType) {
+				return fmt.Errorf("invalid distinguished name (%s) : invalid attribute type %q", name, attributeType)
+			}
+			if strings.TrimSpace(attribute[separator+1:]) == "" {
+				return fmt.Errorf("invalid distinguished name (%s) : empty value for %s", name, attributeType)
+			}
+		}
+	}
+	return nil
+}
+
+// splitUnescaped splits s on the separators not escaped by a backslash.
+func splitUnescaped(s string, separator byte) ([]string, error) {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i == len(s)-1 {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+		case separator:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:]), nil
+}
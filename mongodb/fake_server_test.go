@@ -0,0 +1,239 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/binary"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer speaks enough of the MongoDB wire protocol for the driver to connect, authenticate
+// and run commands, so the provider can be tested without a mongod.
+
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+var fakeServiceID = primitive.NewObjectID()
+
+// fakeHandler answers a command, ok = false falls back to the default replies.
+type fakeHandler func(command string, database string, body bson.Raw) (reply bson.D, ok bool)
+
+type fakeServer struct {
+	listener net.Listener
+	handler  fakeHandler
+
+	lock        sync.Mutex
+	commands    []string
+	bodies      []bson.Raw
+	connections int
+}
+
+func startFakeServer(t testing.TB, listener net.Listener, handler fakeHandler) *fakeServer {
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &fakeServer{listener: listener, handler: handler}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.lock.Lock()
+			server.connections++
+			server.lock.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+// received returns the names of the commands received so far, except the handshakes and heartbeats.
+func (s *fakeServer) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// sent returns the bodies of the commands with the given name received so far, in order.
+func (s *fakeServer) sent(command string) []bson.Raw {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var bodies []bson.Raw
+	for i, name := range s.commands {
+		if name == command {
+			bodies = append(bodies, s.bodies[i])
+		}
+	}
+	return bodies
+}
+
+func (s *fakeServer) connectionCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.connections
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := int(binary.LittleEndian.Uint32(header[0:4]))
+		requestID := binary.LittleEndian.Uint32(header[4:8])
+		opCode := binary.LittleEndian.Uint32(header[12:16])
+		message := make([]byte, length-16)
+		if _, err := io.ReadFull(conn, message); err != nil {
+			return
+		}
+
+		var body bson.Raw
+		var database string
+		switch opCode {
+		case opQuery:
+			// flags, full collection name, skip, return, query
+			end := 4 + strings.IndexByte(string(message[4:]), 0)
+			database = strings.TrimSuffix(string(message[4:end]), ".$cmd")
+			body = bson.Raw(message[end+1+8:])
+			body = body[:binary.LittleEndian.Uint32(body[0:4])]
+		case opMsg:
+			// flags, then a single kind 0 section holding the command
+			body = bson.Raw(message[5:])
+			body = body[:binary.LittleEndian.Uint32(body[0:4])]
+			database, _ = body.Lookup("$db").StringValueOK()
+		default:
+			return
+		}
+
+		reply := s.reply(body, database)
+		document, err := bson.Marshal(reply)
+		if err != nil {
+			return
+		}
+		var response []byte
+		if opCode == opQuery {
+			response = make([]byte, 36)
+			binary.LittleEndian.PutUint32(response[12:16], opReply)
+			binary.LittleEndian.PutUint32(response[32:36], 1)
+		} else {
+			response = make([]byte, 21)
+			binary.LittleEndian.PutUint32(response[12:16], opMsg)
+		}
+		response = append(response, document...)
+		binary.LittleEndian.PutUint32(response[0:4], uint32(len(response)))
+		binary.LittleEndian.PutUint32(response[8:12], requestID)
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) reply(body bson.Raw, database string) bson.D {
+	elements, err := body.Elements()
+	if err != nil || len(elements) == 0 {
+		return bson.D{{Key: "ok", Value: 0}, {Key: "errmsg", Value: "invalid command"}}
+	}
+	command := elements[0].Key()
+
+	switch strings.ToLower(command) {
+	case "ismaster", "hello":
+		hello := bson.D{
+			{Key: "ismaster", Value: true},
+			{Key: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+			{Key: "maxMessageSizeBytes", Value: 48000000},
+			{Key: "maxWriteBatchSize", Value: 100000},
+			{Key: "localTime", Value: time.Now()},
+			{Key: "minWireVersion", Value: 0},
+			{Key: "maxWireVersion", Value: 8},
+			{Key: "ok", Value: 1},
+		}
+		// a load balanced client requires the mongos behind the balancer to identify itself
+		if loadBalanced, _ := body.Lookup("loadBalanced").BooleanOK(); loadBalanced {
+			hello = append(hello, bson.E{Key: "msg", Value: "isdbgrid"}, bson.E{Key: "serviceId", Value: fakeServiceID})
+		}
+		return hello
+	}
+
+	s.lock.Lock()
+	s.commands = append(s.commands, command)
+	s.bodies = append(s.bodies, append(bson.Raw(nil), body...))
+	s.lock.Unlock()
+
+	if s.handler != nil {
+		if reply, ok := s.handler(command, database, body); ok {
+			return reply
+		}
+	}
+	switch command {
+	case "ping", "endSessions":
+		return bson.D{{Key: "ok", Value: 1}}
+	case "usersInfo":
+		return bson.D{{Key: "users", Value: bson.A{}}, {Key: "ok", Value: 1}}
+	case "rolesInfo":
+		return bson.D{{Key: "roles", Value: bson.A{}}, {Key: "ok", Value: 1}}
+	}
+	return bson.D{{Key: "ok", Value: 0}, {Key: "errmsg", Value: "no such command: " + command}, {Key: "code", Value: 59}}
+}
+
+// commandError is the reply of a failed command.
+func commandError(code int, name string, message string) bson.D {
+	return bson.D{{Key: "ok", Value: 0}, {Key: "errmsg", Value: message}, {Key: "code", Value: code}, {Key: "codeName", Value: name}}
+}
+
+// fakeClientConfig returns a provider configuration reaching the fake server on tcp.
+func fakeClientConfig(server *fakeServer) *ClientConfig {
+	host, port, _ := net.SplitHostPort(server.address())
+	return &ClientConfig{
+		Host:                   host,
+		Port:                   port,
+		Direct:                 true,
+		ConnectTimeout:         5 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+	}
+}
+
+// fakeConfiguration returns the provider meta for the fake server, disconnected at the end of the test.
+func fakeConfiguration(t *testing.T, server *fakeServer) *MongoDatabaseConfiguration {
+	conf := &MongoDatabaseConfiguration{Config: fakeClientConfig(server), MaxConnLifetime: 10 * time.Second}
+	t.Cleanup(func() { _ = conf.Disconnect(context.Background()) })
+	return conf
+}
+
+// laggingConfiguration returns the provider meta reading from secondaries, load balanced so the driver
+// sends the read preference of every command to the fake server.
+func laggingConfiguration(t *testing.T, server *fakeServer) *MongoDatabaseConfiguration {
+	conf := fakeConfiguration(t, server)
+	conf.Config.Direct = false
+	conf.Config.LoadBalanced = true
+	conf.Config.ReadPreference = "secondary"
+	return conf
+}
+
+// readPreferenceMode returns the read preference mode a command was sent with, the driver omits the primary.
+func readPreferenceMode(body bson.Raw) string {
+	if mode, ok := body.Lookup("$readPreference", "mode").StringValueOK(); ok {
+		return mode
+	}
+	return "primary"
+}
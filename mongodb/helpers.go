@@ -1,10 +1,13 @@
 package mongodb
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"strings"
 )
 
 func validateDiagFunc(validateFunc func(interface{}, string) ([]string, []error)) schema.SchemaValidateDiagFunc {
@@ -26,3 +29,98 @@ func validateDiagFunc(validateFunc func(interface{}, string) ([]string, []error)
 		return diags
 	}
 }
+
+func expandStringList(list []interface{}) []string {
+	result := make([]string, 0, len(list))
+	for _, v := range list {
+		result = append(result, v.(string))
+	}
+	return result
+}
+
+func expandStringMap(m map[string]interface{}) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v.(string)
+	}
+	return result
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeJSON sorts the object keys and drops the insignificant whitespace, so equivalent documents compare equal.
+func normalizeJSON(value string) string {
+	if value == "" {
+		return ""
+	}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return value
+	}
+	normalized, err := json.Marshal(decoded)
+	if err != nil {
+		return value
+	}
+	return string(normalized)
+}
+
+func expandAuthenticationRestrictions(list []interface{}) []AuthenticationRestriction {
+	restrictions := make([]AuthenticationRestriction, 0, len(list))
+	for _, v := range list {
+		restriction := AuthenticationRestriction{}
+		if v != nil {
+			m := v.(map[string]interface{})
+			restriction.ClientSource = expandStringList(m["client_source"].([]interface{}))
+			restriction.ServerAddress = expandStringList(m["server_address"].([]interface{}))
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions
+}
+
+func flattenAuthenticationRestrictions(restrictions []AuthenticationRestriction) []interface{} {
+	result := make([]interface{}, len(restrictions))
+	for i, restriction := range restrictions {
+		result[i] = map[string]interface{}{
+			"client_source":  restriction.ClientSource,
+			"server_address": restriction.ServerAddress,
+		}
+	}
+	return result
+}
+
+func authenticationRestrictionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"client_source": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type:             schema.TypeString,
+						ValidateDiagFunc: validateDiagFunc(validation.Any(validation.IsCIDR, validation.IsIPAddress)),
+					},
+				},
+				"server_address": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type:             schema.TypeString,
+						ValidateDiagFunc: validateDiagFunc(validation.Any(validation.IsCIDR, validation.IsIPAddress)),
+					},
+				},
+			},
+		},
+	}
+}
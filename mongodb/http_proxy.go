@@ -0,0 +1,94 @@
+package mongodb
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpConnectDialer tunnels the driver connections through an http or https proxy with the CONNECT method.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  proxy.ContextDialer
+}
+
+func newHTTPConnectDialer(proxyURL *url.URL, forward proxy.ContextDialer) *httpConnectDialer {
+	return &httpConnectDialer{
+		proxyURL: proxyURL,
+		forward:  forward,
+	}
+}
+
+func (h *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return h.DialContext(context.Background(), network, address)
+}
+
+func (h *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	proxyAddress := h.proxyURL.Host
+	if h.proxyURL.Port() == "" {
+		port := "80"
+		if h.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddress = net.JoinHostPort(h.proxyURL.Hostname(), port)
+	}
+
+	conn, err := h.forward.DialContext(ctx, network, proxyAddress)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the proxy %s : %s", proxyAddress, err)
+	}
+	if h.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: h.proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("tls handshake with the proxy %s failed : %s", proxyAddress, err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := h.connect(conn, address); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func (h *httpConnectDialer) connect(conn net.Conn, address string) error {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := h.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return fmt.Errorf("could not send CONNECT to the proxy : %s", err)
+	}
+
+	// the server only speaks once the driver sent its handshake, nothing is buffered past the response
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return fmt.Errorf("could not read the proxy CONNECT response : %s", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT to %s : %s", address, response.Status)
+	}
+	return nil
+}
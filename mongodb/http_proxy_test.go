@@ -0,0 +1,125 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectProxy is a local http proxy accepting CONNECT with the given basic auth credentials.
+type connectProxy struct {
+	server *httptest.Server
+
+	lock           sync.Mutex
+	targets        []string
+	authorizations []string
+}
+
+func startConnectProxy(t *testing.T, username string, password string) *connectProxy {
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	p := &connectProxy{}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.lock.Lock()
+		p.targets = append(p.targets, r.Host)
+		p.authorizations = append(p.authorizations, r.Header.Get("Proxy-Authorization"))
+		p.lock.Unlock()
+
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != expected {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = target.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(target, buffered)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *connectProxy) url(username string, password string) *url.URL {
+	proxyURL, _ := url.Parse(p.server.URL)
+	proxyURL.User = url.UserPassword(username, password)
+	return proxyURL
+}
+
+func (p *connectProxy) requests() ([]string, []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.targets...), append([]string(nil), p.authorizations...)
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	httpProxy := startConnectProxy(t, "proxy-user", "proxy-secret")
+
+	config := fakeClientConfig(server)
+	config.Proxy = httpProxy.url("proxy-user", "proxy-secret").String()
+	conf := &MongoDatabaseConfiguration{Config: config, MaxConnLifetime: 10 * time.Second}
+	defer conf.Disconnect(context.Background())
+
+	client, err := MongoClientInit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getUser(context.Background(), client, "user", "admin", false); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, authorizations := httpProxy.requests()
+	if len(targets) == 0 {
+		t.Fatal("expected the driver connections to go through the proxy")
+	}
+	for i, target := range targets {
+		if target != server.address() {
+			t.Errorf("CONNECT to %s, expected %s", target, server.address())
+		}
+		if authorizations[i] != "Basic "+base64.StdEncoding.EncodeToString([]byte("proxy-user:proxy-secret")) {
+			t.Errorf("unexpected Proxy-Authorization %q", authorizations[i])
+		}
+	}
+}
+
+func TestHTTPConnectProxyRefused(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	httpProxy := startConnectProxy(t, "proxy-user", "proxy-secret")
+
+	dialer := newHTTPConnectDialer(httpProxy.url("proxy-user", "wrong"), &net.Dialer{})
+	conn, err := dialer.DialContext(context.Background(), "tcp", server.address())
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("expected the proxy to refuse the CONNECT")
+	}
+	if !strings.Contains(err.Error(), "407") {
+		t.Errorf("expected the refusal status in the error, got %s", err)
+	}
+	if count := server.connectionCount(); count != 0 {
+		t.Errorf("expected no connection to reach the server, got %d", count)
+	}
+}
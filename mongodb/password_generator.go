@@ -0,0 +1,168 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"math/big"
+	"strings"
+)
+
+const (
+	lowerCharacters   = "abcdefghijklmnopqrstuvwxyz"
+	upperCharacters   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numericCharacters = "0123456789"
+	specialCharacters = "!#$%&*()-_=+[]{}<>:?"
+)
+
+func generatePasswordSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:          schema.TypeList,
+		Optional:      true,
+		MaxItems:      1,
+		ConflictsWith: []string{"password", "password_file"},
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"length": {
+					Type:             schema.TypeInt,
+					Optional:         true,
+					Default:          32,
+					ValidateDiagFunc: validateDiagFunc(validation.IntBetween(8, 256)),
+				},
+				"lower": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"upper": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"numeric": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"special": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"exclude_characters": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"keepers": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+			},
+		},
+	}
+}
+
+// generatePassword returns a random password following the generate_password block, it holds at least
+// one character of every enabled class.
+func generatePassword(settings map[string]interface{}) (string, error) {
+	length := 32
+	if v, ok := settings["length"].(int); ok {
+		length = v
+	}
+	exclude, _ := settings["exclude_characters"].(string)
+
+	var classes []string
+	for _, class := range []struct {
+		key        string
+		characters string
+	}{
+		{"lower", lowerCharacters},
+		{"upper", upperCharacters},
+		{"numeric", numericCharacters},
+		{"special", specialCharacters},
+	} {
+		if enabled, ok := settings[class.key].(bool); ok && !enabled {
+			continue
+		}
+		characters := removeCharacters(class.characters, exclude)
+		if characters == "" {
+			return "", fmt.Errorf("generate_password : every %s character is excluded", class.key)
+		}
+		classes = append(classes, characters)
+	}
+	if len(classes) == 0 {
+		return "", fmt.Errorf("generate_password : at least one character class must be enabled")
+	}
+	if length < len(classes) {
+		return "", fmt.Errorf("generate_password : length %d is too short for %d character classes", length, len(classes))
+	}
+
+	password := make([]byte, 0, length)
+	for _, characters := range classes {
+		c, err := randomCharacter(characters)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	all := strings.Join(classes, "")
+	for len(password) < length {
+		c, err := randomCharacter(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// the characters of each class come first, shuffle them away
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+func randomCharacter(characters string) (byte, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(characters))))
+	if err != nil {
+		return 0, err
+	}
+	return characters[i.Int64()], nil
+}
+
+func removeCharacters(characters string, exclude string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(exclude, r) {
+			return -1
+		}
+		return r
+	}, characters)
+}
+
+// generatedPasswordDiff shows generated_password as unknown in the plan when the password is generated again.
+func generatedPasswordDiff(ctx context.Context, diff *schema.ResourceDiff, i interface{}) error {
+	if _, ok := diff.GetOk("generate_password"); ok && diff.HasChange("generate_password") {
+		return diff.SetNewComputed("generated_password")
+	}
+	return nil
+}
+
+// generatePasswordSettings returns the generate_password block, or nil when it is not set.
+func generatePasswordSettings(data *schema.ResourceData) map[string]interface{} {
+	blocks := data.Get("generate_password").([]interface{})
+	if len(blocks) == 0 {
+		return nil
+	}
+	if settings, ok := blocks[0].(map[string]interface{}); ok {
+		return settings
+	}
+	return map[string]interface{}{}
+}
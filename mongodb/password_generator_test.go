@@ -0,0 +1,102 @@
+package mongodb
+
+import (
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"strings"
+	"testing"
+)
+
+func TestGeneratePasswordLength(t *testing.T) {
+	for _, length := range []int{8, 32, 256} {
+		password, err := generatePassword(map[string]interface{}{"length": length})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != length {
+			t.Errorf("expected %d characters, got %d", length, len(password))
+		}
+	}
+}
+
+func TestGeneratePasswordClasses(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		password, err := generatePassword(map[string]interface{}{"length": 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, class := range []string{lowerCharacters, upperCharacters, numericCharacters, specialCharacters} {
+			if !strings.ContainsAny(password, class) {
+				t.Fatalf("%s holds no character of %s", password, class)
+			}
+		}
+	}
+
+	password, err := generatePassword(map[string]interface{}{"length": 64, "upper": false, "special": false, "exclude_characters": "0O1lI"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(password, upperCharacters+specialCharacters+"0O1lI") {
+		t.Errorf("%s holds a disabled or excluded character", password)
+	}
+}
+
+func TestGeneratePasswordInvalidSettings(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"excluded class": {"length": 16, "exclude_characters": numericCharacters},
+		"no class":       {"length": 16, "lower": false, "upper": false, "numeric": false, "special": false},
+		"too short":      {"length": 3},
+	}
+	for name, settings := range tests {
+		if _, err := generatePassword(settings); err == nil {
+			t.Errorf("%s: expected the settings to be rejected", name)
+		}
+	}
+}
+
+func generatedPasswordState(length string) *terraform.InstanceState {
+	return &terraform.InstanceState{
+		ID: formatResourceId("admin", "user"),
+		Attributes: map[string]string{
+			"id":                                     formatResourceId("admin", "user"),
+			"auth_database":                          "admin",
+			"name":                                   "user",
+			"generated_password":                     "generated",
+			"digest_password":                        "true",
+			"verify_password":                        "false",
+			"generate_password.#":                    "1",
+			"generate_password.0.length":             length,
+			"generate_password.0.lower":              "true",
+			"generate_password.0.upper":              "true",
+			"generate_password.0.numeric":            "true",
+			"generate_password.0.special":            "true",
+			"generate_password.0.exclude_characters": "",
+			"generate_password.0.keepers.%":          "0",
+		},
+	}
+}
+
+func TestGeneratedPasswordPlan(t *testing.T) {
+	raw := map[string]interface{}{
+		"auth_database":     "admin",
+		"name":              "user",
+		"generate_password": []interface{}{map[string]interface{}{"length": 32}},
+	}
+
+	diff, err := planDatabaseUser(generatedPasswordState("32"), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil {
+		if _, ok := diff.Attributes["generated_password"]; ok {
+			t.Error("expected an unchanged generate_password block to keep the password")
+		}
+	}
+
+	diff, err = planDatabaseUser(generatedPasswordState("24"), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attribute, ok := diff.Attributes["generated_password"]; !ok || !attribute.NewComputed {
+		t.Error("expected generated_password to be unknown until the password is generated again")
+	}
+}
@@ -0,0 +1,134 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/pbkdf2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// password_file keeps the plaintext password out of the configuration and the state, the file is read on
+// plan and apply and only a salted PBKDF2 hash of its content is stored in password_hash, written
+// pbkdf2-sha256$iterations$salt$hash.
+//
+// The plan compares the file with the hash in state and marks the hash unknown when they differ, the apply
+// then sets the password of the user and hashes it with a new random salt.
+
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 600000
+	passwordHashSaltSize   = 16
+)
+
+// readPasswordFile returns the password held in path, without the trailing newline.
+func readPasswordFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read password_file %s : %s", path, err)
+	}
+	password := strings.TrimRight(string(content), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password_file %s is empty", path)
+	}
+	return password, nil
+}
+
+// passwordFileDiff marks password_hash unknown when the content of password_file does not match it.
+// Removing password_file leaves the password of the user as it is and clears the hash.
+func passwordFileDiff(ctx context.Context, diff *schema.ResourceDiff, i interface{}) error {
+	if !diff.NewValueKnown("password_file") {
+		return diff.SetNewComputed("password_hash")
+	}
+	hash := diff.Get("password_hash").(string)
+	path := diff.Get("password_file").(string)
+	if path == "" {
+		if hash != "" {
+			return diff.SetNew("password_hash", "")
+		}
+		return nil
+	}
+	password, err := readPasswordFile(path)
+	if err != nil {
+		return err
+	}
+	if passwordMatchesHash(hash, password) {
+		return nil
+	}
+	return diff.SetNewComputed("password_hash")
+}
+
+// hashPassword hashes password with a new random salt.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordHashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate the password salt : %s", err)
+	}
+	return formatPasswordHash(passwordHashIterations, salt, password), nil
+}
+
+func formatPasswordHash(iterations int, salt []byte, password string) string {
+	hash := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	return strings.Join([]string{
+		passwordHashScheme,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	}, "$")
+}
+
+// parsePasswordHash returns the iterations and the salt of a hash written by formatPasswordHash.
+func parsePasswordHash(hash string) (int, []byte, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return 0, nil, false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return 0, nil, false
+	}
+	return iterations, salt, true
+}
+
+// passwordMatchesHash reports whether password is the one hashed in hash.
+func passwordMatchesHash(hash string, password string) bool {
+	iterations, salt, ok := parsePasswordHash(hash)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(formatPasswordHash(iterations, salt, password)), []byte(hash)) == 1
+}
+
+// configuredPassword returns the plaintext password of the user and whether it is known, password_file
+// is read.
+func configuredPassword(data *schema.ResourceData) (string, bool, error) {
+	if generatePasswordSettings(data) != nil {
+		password := data.Get("generated_password").(string)
+		return password, password != "", nil
+	}
+	if path := data.Get("password_file").(string); path != "" {
+		password, err := readPasswordFile(path)
+		if err != nil {
+			return "", false, err
+		}
+		return password, true, nil
+	}
+	password := data.Get("password").(string)
+	return password, password != "", nil
+}
+
+// passwordFileChanged reports whether the content of password_file differs from the hash in state.
+func passwordFileChanged(data *schema.ResourceData, password string) bool {
+	hash, _ := data.GetChange("password_hash")
+	return !passwordMatchesHash(hash.(string), password)
+}
@@ -0,0 +1,108 @@
+package mongodb
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePasswordFile(t *testing.T, password string) string {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(path, []byte(password+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == other {
+		t.Error("expected every hash to get a new salt")
+	}
+	iterations, salt, ok := parsePasswordHash(hash)
+	if !ok || iterations != passwordHashIterations || len(salt) != passwordHashSaltSize {
+		t.Errorf("unexpected hash %s", hash)
+	}
+	if !passwordMatchesHash(hash, "secret") {
+		t.Errorf("%s is not a hash of the password", hash)
+	}
+	if passwordMatchesHash(hash, "changed") {
+		t.Error("expected another password not to match")
+	}
+	for _, invalid := range []string{"", "secret", "pbkdf2-sha256$0$c2FsdA$aGFzaA", "md5$1$c2FsdA$aGFzaA"} {
+		if passwordMatchesHash(invalid, "secret") {
+			t.Errorf("expected %q not to match", invalid)
+		}
+	}
+}
+
+func TestPasswordFileApply(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createUser", "updateUser":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "usersInfo":
+			user := bson.D{{Key: "user", Value: "user"}, {Key: "db", Value: "admin"}, {Key: "roles", Value: bson.A{}}, {Key: "mechanisms", Value: bson.A{"SCRAM-SHA-256"}}}
+			return bson.D{{Key: "users", Value: bson.A{user}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	conf := fakeConfiguration(t, server)
+	passwordFile := writePasswordFile(t, "secret")
+	raw := map[string]interface{}{"auth_database": "admin", "name": "user", "password_file": passwordFile}
+
+	state, err := applyResource(resourceDatabaseUser(), nil, raw, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pwd := server.sent("createUser")[0].Lookup("pwd").StringValue(); pwd != "secret" {
+		t.Errorf("expected createUser to set the content of password_file, got %q", pwd)
+	}
+	for key, value := range state.Attributes {
+		if strings.Contains(value, "secret") {
+			t.Errorf("expected the plaintext to stay out of state, found it in %s", key)
+		}
+	}
+	if !passwordMatchesHash(state.Attributes["password_hash"], "secret") {
+		t.Fatalf("expected password_hash to hash the password, got %q", state.Attributes["password_hash"])
+	}
+
+	diff, err := planDatabaseUser(state, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected an unchanged password_file to plan nothing, got %#v", diff.Attributes)
+	}
+
+	if err := ioutil.WriteFile(passwordFile, []byte("changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = planDatabaseUser(state, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attribute, ok := diff.Attributes["password_hash"]; !ok || !attribute.NewComputed {
+		t.Fatalf("expected a changed password_file to plan a new hash, got %#v", diff.Attributes)
+	}
+	state, err = applyResource(resourceDatabaseUser(), state, raw, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := server.sent("updateUser")
+	if len(updates) != 1 || updates[0].Lookup("pwd").StringValue() != "changed" {
+		t.Fatalf("expected updateUser to set the new content of password_file, got %v", updates)
+	}
+	if !passwordMatchesHash(state.Attributes["password_hash"], "changed") {
+		t.Errorf("expected password_hash to hash the new password, got %q", state.Attributes["password_hash"])
+	}
+}
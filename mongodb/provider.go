@@ -5,25 +5,55 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io/ioutil"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
+			"uri": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				DefaultFunc:      schema.EnvDefaultFunc("MONGO_URI", nil),
+				ConflictsWith:    []string{"host", "port", "hosts", "socket_path"},
+				Description:      "The mongodb connection string, mongodb:// or mongodb+srv://",
+				ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^mongodb(\\+srv)?://.+"), "The uri is not a valid mongodb connection string.")),
+			},
 			"host": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("MONGO_HOST", "127.0.0.1"),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MONGO_HOST", nil),
 				Description: "The mongodb server address",
 			},
 			"port": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("MONGO_PORT", "27017"),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MONGO_PORT", nil),
 				Description: "The mongodb server port",
 			},
+			"hosts": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				ConflictsWith: []string{"host", "port"},
+				Description:   "The mongodb seed list, as host:port entries",
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^(\\[[0-9a-fA-F:.]+\\]|[^\\s,/:\\[\\]]+):\\d+$"), "The host is not a valid host:port entry.")),
+				},
+			},
+			"socket_path": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ConflictsWith:    []string{"host", "port", "hosts"},
+				Description:      "The mongodb unix domain socket, e.g. /tmp/mongodb-27017.sock",
+				ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^/.*\\.sock$"), "The socket path must be absolute and end with .sock.")),
+			},
 			"certificate": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -31,23 +61,116 @@ func Provider() *schema.Provider {
 				Description: "PEM-encoded content of Mongodb host CA certificate",
 			},
 
+			"certificate_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MONGODB_CERT_FILE", nil),
+				Description: "Path to the PEM-encoded Mongodb host CA certificate, instead of certificate",
+			},
+			"tls_append_system_roots": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "trust the system certificate pool in addition to certificate or certificate_file",
+			},
+			"tls_min_version": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "The minimum TLS version accepted, one of 1.0, 1.1, 1.2 or 1.3",
+				ValidateDiagFunc: validateDiagFunc(validation.StringInSlice(tlsVersionNames(), false)),
+			},
+			"tls_cipher_suites": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The TLS 1.0-1.2 cipher suites accepted, by their Go name",
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateDiagFunc(validation.StringInSlice(tlsCipherSuiteNames(), false)),
+				},
+			},
+			"tls_server_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The server name used for SNI and certificate verification instead of the host",
+			},
+			"client_certificate": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MONGODB_CLIENT_CERT", nil),
+				Description: "PEM-encoded content, or path, of the client certificate used for mutual TLS",
+			},
+			"client_key": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				DefaultFunc:  schema.EnvDefaultFunc("MONGODB_CLIENT_KEY", nil),
+				RequiredWith: []string{"client_certificate"},
+				Description:  "PEM-encoded content, or path, of the client certificate private key, defaults to the client_certificate content",
+			},
+			"auth_mechanism": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "The mongodb authentication mechanism, negotiated by the driver when empty",
+				ValidateDiagFunc: validateDiagFunc(validation.StringInSlice(authMechanisms, false)),
+			},
+			"auth_mechanism_properties": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Additional properties of the authentication mechanism, only used by GSSAPI",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"username": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("MONGO_USR", nil),
 				Description: "The mongodb user",
 			},
 			"password": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Sensitive:   true,
 				DefaultFunc: schema.EnvDefaultFunc("MONGO_PWD", nil),
 				Description: "The mongodb password",
 			},
+			"password_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"password"},
+				Description:   "Path to a file holding the mongodb password",
+			},
+			"credential_process": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"password", "password_file"},
+				Description:   "A local command printing the mongodb credentials as json {username, password, expires_at}",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"command": {
+							Type:        schema.TypeList,
+							Required:    true,
+							MinItems:    1,
+							Description: "The command and its arguments",
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"timeout": {
+							Type:             schema.TypeInt,
+							Optional:         true,
+							Default:          30,
+							Description:      "Seconds to wait for the command to return",
+							ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(1)),
+						},
+					},
+				},
+			},
 			"auth_database": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				Default:     "admin",
-				Description: "The mongodb auth database",
+				Description: "The mongodb auth database, defaults to the uri authSource or admin",
 			},
 			"replica_set": {
 				Type:        schema.TypeString,
@@ -73,12 +196,103 @@ func Provider() *schema.Provider {
 				Default:     false,
 				Description: "enforces a direct connection instead of discovery",
 			},
+			"load_balanced": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "connect to mongos behind a L4 load balancer",
+			},
 			"retrywrites": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				Default:     true,
 				Description: "Retryable Writes",
 			},
+			"connect_timeout": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          10,
+				Description:      "Seconds to wait for a connection to be established",
+				ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(1)),
+			},
+			"server_selection_timeout": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          30,
+				Description:      "Seconds to wait for a suitable server to be found before failing an operation",
+				ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(1)),
+			},
+			"socket_timeout": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          0,
+				Description:      "Seconds to wait for a socket read or write to return, 0 means no timeout",
+				ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(0)),
+			},
+			"heartbeat_interval": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          10,
+				Description:      "Seconds to wait between server monitoring checks",
+				ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(1)),
+			},
+			"app_name": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "terraform-provider-mongodb",
+				Description:      "The application name reported to the server, visible in currentOp and the server logs",
+				ValidateDiagFunc: validateDiagFunc(validation.StringLenBetween(1, 128)),
+			},
+			"compressors": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The wire compressors to negotiate, in order of preference",
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateDiagFunc(validation.StringInSlice([]string{"zstd", "snappy", "zlib"}, false)),
+				},
+			},
+			"read_preference": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "The read preference of the users and roles reads",
+				ValidateDiagFunc: validateDiagFunc(validation.StringInSlice([]string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"}, false)),
+			},
+			"write_concern": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "The write concern of the users and roles changes",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"w": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							Description:      "majority, a number of members or a tag set name",
+							ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^[A-Za-z0-9_-]+$"), "w must be majority, a number or a tag set name.")),
+						},
+						"journal": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "wait for the write to be written to the journal",
+						},
+						"timeout_ms": {
+							Type:             schema.TypeInt,
+							Optional:         true,
+							Default:          0,
+							Description:      "Milliseconds to wait for the write concern, 0 means no limit",
+							ValidateDiagFunc: validateDiagFunc(validation.IntAtLeast(0)),
+						},
+					},
+				},
+			},
+			"server_api_version": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "The Stable API version to declare, only 1 is supported",
+				ValidateDiagFunc: validateDiagFunc(validation.StringInSlice([]string{"1"}, false)),
+			},
 			"proxy": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -86,7 +300,77 @@ func Provider() *schema.Provider {
 					"ALL_PROXY",
 					"all_proxy",
 				}, nil),
-				ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^socks5h?://.*:\\d+$"), "The proxy URL is not a valid socks url.")),
+				ValidateDiagFunc: validateDiagFunc(validation.StringMatch(regexp.MustCompile("^(socks5h?://.*:\\d+|https?://.+)$"), "The proxy URL is not a valid socks, http or https url.")),
+			},
+			"no_proxy": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NO_PROXY",
+					"no_proxy",
+				}, nil),
+				Description: "Comma separated hosts, domains and networks reached without the proxy",
+			},
+			"ssh_tunnel": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Reach the mongodb server through an ssh bastion",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"host": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The ssh bastion address",
+						},
+						"port": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "22",
+							Description: "The ssh bastion port",
+						},
+						"user": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The ssh user",
+						},
+						"private_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "PEM-encoded content, or path, of the ssh private key",
+						},
+						"private_key_passphrase": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "The passphrase of the ssh private key",
+						},
+						"use_agent": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "authenticate with the keys of the ssh agent listening on SSH_AUTH_SOCK",
+						},
+						"known_hosts_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "~/.ssh/known_hosts",
+							Description: "The known_hosts file used to verify the bastion host key",
+						},
+						"host_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The expected bastion host key, in authorized_keys format, instead of known_hosts_file",
+						},
+						"insecure_ignore_host_key": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "skip the bastion host key verification",
+						},
+					},
+				},
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
@@ -101,29 +385,136 @@ func Provider() *schema.Provider {
 type MongoDatabaseConfiguration struct {
 	Config          *ClientConfig
 	MaxConnLifetime time.Duration
+
+	clientLock     sync.Mutex
+	client         *mongo.Client
+	retiredClients []*mongo.Client
 }
 
 func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	clientConfig := ClientConfig{
-		Host:               d.Get("host").(string),
-		Port:               d.Get("port").(string),
-		Username:           d.Get("username").(string),
-		Password:           d.Get("password").(string),
-		DB:                 d.Get("auth_database").(string),
-		Ssl:                d.Get("ssl").(bool),
-		ReplicaSet:         d.Get("replica_set").(string),
-		Certificate:        d.Get("certificate").(string),
-		InsecureSkipVerify: d.Get("insecure_skip_verify").(bool),
-		Direct:             d.Get("direct").(bool),
-		RetryWrites:        d.Get("retrywrites").(bool),
-		Proxy:              d.Get("proxy").(string),
+		URI:                     d.Get("uri").(string),
+		Host:                    d.Get("host").(string),
+		Port:                    d.Get("port").(string),
+		Hosts:                   expandStringList(d.Get("hosts").([]interface{})),
+		SocketPath:              d.Get("socket_path").(string),
+		Username:                d.Get("username").(string),
+		Password:                d.Get("password").(string),
+		DB:                      d.Get("auth_database").(string),
+		Ssl:                     d.Get("ssl").(bool),
+		ReplicaSet:              d.Get("replica_set").(string),
+		Certificate:             d.Get("certificate").(string),
+		CertificateFile:         d.Get("certificate_file").(string),
+		TLSSystemRoots:          d.Get("tls_append_system_roots").(bool),
+		TLSMinVersion:           d.Get("tls_min_version").(string),
+		TLSCipherSuites:         expandStringList(d.Get("tls_cipher_suites").([]interface{})),
+		TLSServerName:           d.Get("tls_server_name").(string),
+		ClientCertificate:       d.Get("client_certificate").(string),
+		ClientKey:               d.Get("client_key").(string),
+		AuthMechanism:           d.Get("auth_mechanism").(string),
+		AuthMechanismProperties: expandStringMap(d.Get("auth_mechanism_properties").(map[string]interface{})),
+		InsecureSkipVerify:      d.Get("insecure_skip_verify").(bool),
+		Direct:                  d.Get("direct").(bool),
+		LoadBalanced:            d.Get("load_balanced").(bool),
+		RetryWrites:             d.Get("retrywrites").(bool),
+		Proxy:                   d.Get("proxy").(string),
+		NoProxy:                 d.Get("no_proxy").(string),
+
+		ConnectTimeout:         time.Duration(d.Get("connect_timeout").(int)) * time.Second,
+		ServerSelectionTimeout: time.Duration(d.Get("server_selection_timeout").(int)) * time.Second,
+		SocketTimeout:          time.Duration(d.Get("socket_timeout").(int)) * time.Second,
+		HeartbeatInterval:      time.Duration(d.Get("heartbeat_interval").(int)) * time.Second,
+
+		AppName:          d.Get("app_name").(string),
+		Compressors:      expandStringList(d.Get("compressors").([]interface{})),
+		ReadPreference:   d.Get("read_preference").(string),
+		ServerAPIVersion: d.Get("server_api_version").(string),
+	}
+
+	if writeConcerns := d.Get("write_concern").([]interface{}); len(writeConcerns) > 0 && writeConcerns[0] != nil {
+		writeConcern := writeConcerns[0].(map[string]interface{})
+		clientConfig.WriteConcern = &WriteConcern{
+			W:       writeConcern["w"].(string),
+			Journal: writeConcern["journal"].(bool),
+			Timeout: time.Duration(writeConcern["timeout_ms"].(int)) * time.Millisecond,
+		}
+	}
+
+	if clientConfig.URI != "" {
+		if _, err := clientConfig.parseURI(); err != nil {
+			return nil, diag.Errorf("Invalid uri : %s ", err)
+		}
+	} else if len(clientConfig.Hosts) == 0 && clientConfig.SocketPath == "" {
+		if clientConfig.Host == "" {
+			clientConfig.Host = "127.0.0.1"
+		}
+		if clientConfig.Port == "" {
+			clientConfig.Port = "27017"
+		}
+	}
+
+	if passwordFile := d.Get("password_file").(string); passwordFile != "" {
+		password, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return nil, diag.Errorf("Could not read password_file : %s ", err)
+		}
+		clientConfig.Password = strings.TrimRight(string(password), "\r\n")
+	}
+
+	if processes := d.Get("credential_process").([]interface{}); len(processes) > 0 && processes[0] != nil {
+		process := processes[0].(map[string]interface{})
+		clientConfig.CredentialProcess = &CredentialProcess{
+			Command: expandStringList(process["command"].([]interface{})),
+			Timeout: time.Duration(process["timeout"].(int)) * time.Second,
+		}
 	}
 
-	return &MongoDatabaseConfiguration{
+	if tunnels := d.Get("ssh_tunnel").([]interface{}); len(tunnels) > 0 && tunnels[0] != nil {
+		tunnel := tunnels[0].(map[string]interface{})
+		clientConfig.SSHTunnel = &SSHTunnelConfig{
+			Host:                  tunnel["host"].(string),
+			Port:                  tunnel["port"].(string),
+			User:                  tunnel["user"].(string),
+			PrivateKey:            tunnel["private_key"].(string),
+			PrivateKeyPassphrase:  tunnel["private_key_passphrase"].(string),
+			UseAgent:              tunnel["use_agent"].(bool),
+			KnownHostsFile:        tunnel["known_hosts_file"].(string),
+			HostKey:               tunnel["host_key"].(string),
+			InsecureIgnoreHostKey: tunnel["insecure_ignore_host_key"].(bool),
+		}
+		if clientConfig.SSHTunnel.PrivateKey == "" && !clientConfig.SSHTunnel.UseAgent {
+			return nil, diag.Errorf("ssh_tunnel requires a private_key or use_agent")
+		}
+	}
+
+	if _, err := proxyDialer(&clientConfig); err != nil {
+		return nil, diag.Errorf("Invalid proxy : %s ", err)
+	}
+
+	if err := clientConfig.validateAuth(); err != nil {
+		return nil, diag.Errorf("%s", err)
+	}
+
+	if err := clientConfig.validateTLS(); err != nil {
+		return nil, diag.Errorf("%s", err)
+	}
+
+	if clientConfig.Direct && len(clientConfig.Hosts) > 1 {
+		return nil, diag.Errorf("direct can not be enabled with more than one host in hosts")
+	}
+
+	if err := clientConfig.validateLoadBalanced(); err != nil {
+		return nil, diag.Errorf("%s", err)
+	}
+
+	configuration := &MongoDatabaseConfiguration{
 		Config:          &clientConfig,
-		MaxConnLifetime: 10,
-	}, diags
+		MaxConnLifetime: time.Duration(d.Get("connect_timeout").(int)) * time.Second,
+	}
+	registerConfiguration(configuration)
+
+	return configuration, diags
 
 }
@@ -0,0 +1,155 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	if err := Provider().InternalValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// configureProvider runs providerConfigure on the given provider block.
+func configureProvider(t *testing.T, raw map[string]interface{}) (*ClientConfig, error) {
+	d := schema.TestResourceDataRaw(t, Provider().Schema, raw)
+	configuration, diags := providerConfigure(context.Background(), d)
+	if diags.HasError() {
+		return nil, errors.New(diags[0].Summary)
+	}
+	return configuration.(*MongoDatabaseConfiguration).Config, nil
+}
+
+// applyResource plans raw against state and applies the plan the way terraform does, the planned value is
+// turned back into a diff by DiffFromValues without the CustomizeDiff and the StateFuncs.
+func applyResource(res *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}, meta interface{}) (*terraform.InstanceState, error) {
+	ctx := context.Background()
+	plan, err := res.SimpleDiff(ctx, state, terraform.NewResourceConfigRaw(raw), meta)
+	if err != nil {
+		return nil, err
+	}
+	schemaType := res.CoreConfigSchema().ImpliedType()
+	prior := cty.NullVal(schemaType)
+	priorAttributes := map[string]string{}
+	if state != nil {
+		prior, err = schema.StateValueFromInstanceState(state, schemaType)
+		if err != nil {
+			return nil, err
+		}
+		priorAttributes = state.Attributes
+	}
+	plannedAttributes, err := plan.Apply(priorAttributes, res.CoreConfigSchema())
+	if err != nil {
+		return nil, err
+	}
+	planned, err := schema.StateValueFromInstanceState(&terraform.InstanceState{Attributes: plannedAttributes}, schemaType)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := schema.DiffFromValues(ctx, prior, planned, stripResourceModifiers(res))
+	if err != nil {
+		return nil, err
+	}
+	if diff == nil {
+		diff = &terraform.InstanceDiff{Attributes: map[string]*terraform.ResourceAttrDiff{}}
+	}
+	newState, diags := res.Apply(ctx, state, diff, meta)
+	if diags.HasError() {
+		return newState, errors.New(diags[0].Summary)
+	}
+	return newState, nil
+}
+
+func stripResourceModifiers(res *schema.Resource) *schema.Resource {
+	stripped := *res
+	stripped.CustomizeDiff = nil
+	stripped.Schema = map[string]*schema.Schema{}
+	for key, value := range res.Schema {
+		strippedSchema := *value
+		strippedSchema.StateFunc = nil
+		if elem, ok := value.Elem.(*schema.Resource); ok {
+			strippedSchema.Elem = stripResourceModifiers(elem)
+		}
+		stripped.Schema[key] = &strippedSchema
+	}
+	return &stripped
+}
+
+func TestProviderConfigureTLS(t *testing.T) {
+	config, err := configureProvider(t, map[string]interface{}{
+		"host":                    "localhost",
+		"ssl":                     true,
+		"certificate_file":        "/etc/ssl/mongo-ca.pem",
+		"tls_append_system_roots": true,
+		"tls_min_version":         "1.2",
+		"tls_cipher_suites":       []interface{}{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		"tls_server_name":         "mongo.internal",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CertificateFile != "/etc/ssl/mongo-ca.pem" || !config.TLSSystemRoots || config.TLSMinVersion != "1.2" || config.TLSServerName != "mongo.internal" {
+		t.Errorf("tls options were not configured: %+v", config)
+	}
+	if !reflect.DeepEqual(config.TLSCipherSuites, []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}) {
+		t.Errorf("tls_cipher_suites = %v", config.TLSCipherSuites)
+	}
+
+	if _, err := configureProvider(t, map[string]interface{}{"host": "localhost", "tls_min_version": "1.2"}); err == nil {
+		t.Error("expected tls_min_version without ssl to be rejected")
+	}
+}
+
+func TestProviderConfigureNoProxy(t *testing.T) {
+	config, err := configureProvider(t, map[string]interface{}{"host": "localhost", "no_proxy": "localhost,10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.NoProxy != "localhost,10.0.0.0/8" {
+		t.Errorf("no_proxy = %q", config.NoProxy)
+	}
+
+	t.Setenv("NO_PROXY", ".internal")
+	config, err = configureProvider(t, map[string]interface{}{"host": "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.NoProxy != ".internal" {
+		t.Errorf("expected no_proxy to default to NO_PROXY, got %q", config.NoProxy)
+	}
+}
+
+func TestProviderConfigureSocketPath(t *testing.T) {
+	config, err := configureProvider(t, map[string]interface{}{"socket_path": "/tmp/mongodb-27017.sock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SocketPath != "/tmp/mongodb-27017.sock" {
+		t.Errorf("socket_path = %q", config.SocketPath)
+	}
+	if connectionString := config.connectionString(); !strings.HasPrefix(connectionString, "mongodb://%2Ftmp%2Fmongodb-27017.sock") {
+		t.Errorf("expected the socket to be percent-encoded in %s", connectionString)
+	}
+}
+
+func TestProviderConfigureLoadBalanced(t *testing.T) {
+	config, err := configureProvider(t, map[string]interface{}{"host": "lb.internal", "load_balanced": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.LoadBalanced {
+		t.Error("load_balanced was not configured")
+	}
+
+	if _, err := configureProvider(t, map[string]interface{}{"uri": "mongodb://lb.internal:27017/?loadBalanced=true", "replica_set": "rs0"}); err == nil {
+		t.Error("expected loadBalanced=true in the uri to be rejected with replica_set")
+	}
+}
@@ -2,13 +2,12 @@ package mongodb
 
 import (
 	"context"
-	"encoding/base64"
-	"fmt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
-	"strings"
+	"log"
+	"time"
 )
 
 func resourceDatabaseRole() *schema.Resource {
@@ -18,17 +17,33 @@ func resourceDatabaseRole() *schema.Resource {
 		UpdateContext: resourceDatabaseRoleUpdate,
 		DeleteContext: resourceDatabaseRoleDelete,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importResourceId,
+		},
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Type:    resourceDatabaseRoleV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: resourceIdStateUpgradeV0,
+				Version: 0,
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
 		},
 		Schema: map[string]*schema.Schema{
 			"database": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default: "admin",
+				ForceNew: true,
 			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"privilege": {
 				Type:     schema.TypeSet,
@@ -56,6 +71,7 @@ func resourceDatabaseRole() *schema.Resource {
 					},
 				},
 			},
+			"authentication_restriction": authenticationRestrictionSchema(),
 			"inherited_role": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -79,7 +95,7 @@ func resourceDatabaseRole() *schema.Resource {
 
 func resourceDatabaseRoleCreate(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
@@ -101,32 +117,32 @@ func resourceDatabaseRoleCreate(ctx context.Context, data *schema.ResourceData,
 	}
 
 
-	err := createRole(client, role, roleList, privileges, database)
+	restrictions := expandAuthenticationRestrictions(data.Get("authentication_restriction").([]interface{}))
+
+	err := createRole(ctx, client, role, roleList, privileges, restrictions, database)
 
 	if err != nil {
 		return diag.Errorf("Could not create the role : %s ", err)
 	}
-	str := database+"."+role
-	encoded := base64.StdEncoding.EncodeToString([]byte(str))
-	data.SetId(encoded)
-	return resourceDatabaseRoleRead(ctx, data, i)
+	data.SetId(formatResourceId(database, role))
+	return readDatabaseRole(ctx, data, i, true)
 }
 
 func resourceDatabaseRoleDelete(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	var stateId = data.State().ID
-	roleName, database , err := resourceDatabaseRoleParseId(stateId)
+	roleName, database , err := parseResourceId(stateId)
 
 	if err != nil {
 		return diag.Errorf("%s", err)
 	}
 
 	db := client.Database(database)
-	result := db.RunCommand(context.Background(), bson.D{{Key: "dropRole", Value: roleName}})
+	result := db.RunCommand(ctx, withWriteConcern(db, bson.D{{Key: "dropRole", Value: roleName}}))
 
 	if result.Err() != nil {
 		return diag.Errorf("%s",result.Err())
@@ -137,71 +153,82 @@ func resourceDatabaseRoleDelete(ctx context.Context, data *schema.ResourceData,
 
 func resourceDatabaseRoleUpdate(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
-	var role = data.Get("name").(string)
 	var stateId = data.State().ID
-	roleName, database , err := resourceDatabaseRoleParseId(stateId)
+	roleName, database , err := parseResourceId(stateId)
 
 	if err != nil {
 		return diag.Errorf("%s",err)
 	}
 
-	db := client.Database(database)
-	result := db.RunCommand(context.Background(), bson.D{{Key: "dropRole", Value: roleName}})
-
-	if result.Err() != nil {
-		return diag.Errorf("%s", result.Err())
+	// updateRole replaces the privileges and inherited roles at once, the role is never dropped
+	// so the users holding it keep it.
+	if data.HasChange("privilege") {
+		var privileges []PrivilegeDto
+		privilege := data.Get("privilege").(*schema.Set).List()
+		privMapErr := mapstructure.Decode(privilege, &privileges)
+		if privMapErr != nil {
+			return diag.Errorf("Error decoding map : %s ", privMapErr)
+		}
+		err = updateRolePrivileges(ctx, client, roleName, privileges, database)
+		if err != nil {
+			return diag.Errorf("Could not update the role privileges : %s ", err)
+		}
 	}
 
-	var roleList []Role
-	var privileges []PrivilegeDto
-
-	privilege := data.Get("privilege").(*schema.Set).List()
-	roles := data.Get("inherited_role").(*schema.Set).List()
-
-	roleMapErr := mapstructure.Decode(roles, &roleList)
-	if roleMapErr != nil {
-		return diag.Errorf("Error decoding map : %s ", roleMapErr)
-	}
-	privMapErr := mapstructure.Decode(privilege, &privileges)
-	if privMapErr != nil {
-		return diag.Errorf("Error decoding map : %s ", privMapErr)
+	if data.HasChange("inherited_role") {
+		var roleList []Role
+		roles := data.Get("inherited_role").(*schema.Set).List()
+		roleMapErr := mapstructure.Decode(roles, &roleList)
+		if roleMapErr != nil {
+			return diag.Errorf("Error decoding map : %s ", roleMapErr)
+		}
+		err = updateRoleInheritedRoles(ctx, client, roleName, roleList, database)
+		if err != nil {
+			return diag.Errorf("Could not update the role inherited roles : %s ", err)
+		}
 	}
 
-	err2 := createRole(client, role, roleList, privileges, database)
-
-	if err2 != nil {
-		return diag.Errorf("Could not create the role  :  %s ", err)
+	if data.HasChange("authentication_restriction") {
+		restrictions := expandAuthenticationRestrictions(data.Get("authentication_restriction").([]interface{}))
+		err = updateRoleAuthenticationRestrictions(ctx, client, roleName, restrictions, database)
+		if err != nil {
+			return diag.Errorf("Could not update the role authentication restrictions : %s ", err)
+		}
 	}
-	str := database+"."+role
-	encoded := base64.StdEncoding.EncodeToString([]byte(str))
-	data.SetId(encoded)
-
 
-	return resourceDatabaseRoleRead(ctx, data, i)
+	return readDatabaseRole(ctx, data, i, true)
 }
 
 func resourceDatabaseRoleRead(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
+	return readDatabaseRole(ctx, data, i, false)
+}
+
+// readDatabaseRole reads the role with the provider read preference, or from the primary after a write so a
+// lagging secondary does not drop it from the state.
+func readDatabaseRole(ctx context.Context, data *schema.ResourceData, i interface{}, fromPrimary bool) diag.Diagnostics {
 	var diags diag.Diagnostics
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	stateID := data.State().ID
-	roleName, database , err := resourceDatabaseRoleParseId(stateID)
+	roleName, database , err := parseResourceId(stateID)
 	if err != nil {
 		return diag.Errorf("%s",err)
 	}
-	result , decodeError := getRole(client,roleName,database)
+	result , decodeError := getRole(ctx, client,roleName,database,fromPrimary)
 	if decodeError != nil {
-		return diag.Errorf("Error decoding role : %s ", err)
+		return diag.Errorf("Error decoding role : %s ", decodeError)
 	}
 	if len(result.Roles) == 0 {
-		return diag.Errorf("Role does not exist")
+		log.Printf("[WARN] role %s does not exist in %s, removing it from state", roleName, database)
+		data.SetId("")
+		return nil
 	}
 	inheritedRoles := make([]interface{}, len(result.Roles[0].InheritedRoles))
 
@@ -228,6 +255,14 @@ func resourceDatabaseRoleRead(ctx context.Context, data *schema.ResourceData, i
 	if dataSetError != nil {
 		return diag.Errorf("Error setting role privilege : %s ", err)
 	}
+	restrictions, restrictionsError := decodeAuthenticationRestrictions(result.Roles[0].AuthenticationRestrictions)
+	if restrictionsError != nil {
+		return diag.Errorf("Error decoding role authentication restrictions : %s ", restrictionsError)
+	}
+	dataSetError = data.Set("authentication_restriction", flattenAuthenticationRestrictions(restrictions))
+	if dataSetError != nil {
+		return diag.Errorf("Error setting role authentication restrictions : %s ", dataSetError)
+	}
 	dataSetError = data.Set("database", database)
 	if dataSetError != nil {
 		return diag.Errorf("Error setting role database : %s ", err)
@@ -242,20 +277,56 @@ func resourceDatabaseRoleRead(ctx context.Context, data *schema.ResourceData, i
 	return diags
 }
 
-func resourceDatabaseRoleParseId(id string) (string, string, error) {
-	result , errEncoding := base64.StdEncoding.DecodeString(id)
-
-	if errEncoding != nil {
-		return "", "", fmt.Errorf("unexpected format of ID Error : %s", errEncoding)
-	}
-	parts := strings.SplitN(string(result), ".", 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", fmt.Errorf("unexpected format of ID (%s), expected database.roleName", id)
+func resourceDatabaseRoleV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"database": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"privilege": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"db": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"collection": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"actions": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+					},
+				},
+			},
+			"inherited_role": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"db": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"role": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+		},
 	}
-
-	database := parts[0]
-	roleName := parts[1]
-
-	return roleName , database , nil
 }
-
@@ -0,0 +1,137 @@
+package mongodb
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"testing"
+)
+
+func TestResourceDatabaseRoleReadNotFound(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	data := schema.TestResourceDataRaw(t, resourceDatabaseRole().Schema, map[string]interface{}{"name": "role", "database": "admin"})
+	data.SetId(formatResourceId("admin", "role"))
+
+	if diags := resourceDatabaseRoleRead(context.Background(), data, fakeConfiguration(t, server)); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if data.Id() != "" {
+		t.Errorf("expected a deleted role to be removed from state, got id %s", data.Id())
+	}
+}
+
+func TestResourceDatabaseRoleReadError(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		return commandError(13, "Unauthorized", "not authorized on admin to execute command"), command == "rolesInfo"
+	})
+	data := schema.TestResourceDataRaw(t, resourceDatabaseRole().Schema, map[string]interface{}{"name": "role", "database": "admin"})
+	data.SetId(formatResourceId("admin", "role"))
+
+	if diags := resourceDatabaseRoleRead(context.Background(), data, fakeConfiguration(t, server)); !diags.HasError() {
+		t.Fatal("expected an authorization failure to be reported")
+	}
+	if data.Id() != formatResourceId("admin", "role") {
+		t.Errorf("expected the role to stay in state, got id %q", data.Id())
+	}
+}
+
+func TestResourceDatabaseRoleCreateReadsFromPrimary(t *testing.T) {
+	var lock sync.Mutex
+	var modes []string
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createRole":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "rolesInfo":
+			mode := readPreferenceMode(body)
+			lock.Lock()
+			modes = append(modes, mode)
+			lock.Unlock()
+			// the secondary has not replicated the role yet
+			if mode == "secondary" {
+				return nil, false
+			}
+			role := bson.D{{Key: "role", Value: "role"}, {Key: "db", Value: "admin"}, {Key: "roles", Value: bson.A{}}, {Key: "privileges", Value: bson.A{}}}
+			return bson.D{{Key: "roles", Value: bson.A{role}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	conf := laggingConfiguration(t, server)
+	data := schema.TestResourceDataRaw(t, resourceDatabaseRole().Schema, map[string]interface{}{"name": "role", "database": "admin"})
+
+	if diags := resourceDatabaseRoleCreate(context.Background(), data, conf); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if data.Id() != formatResourceId("admin", "role") {
+		t.Fatalf("expected the created role to stay in state, got id %q", data.Id())
+	}
+
+	if diags := resourceDatabaseRoleRead(context.Background(), data, conf); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(modes) != 2 || modes[0] != "primary" || modes[1] != "secondary" {
+		t.Errorf("expected the read back on the primary and the refresh on a secondary, got %v", modes)
+	}
+}
+
+func TestResourceDatabaseRoleUpdate(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createRole", "updateRole":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "rolesInfo":
+			role := bson.D{
+				{Key: "role", Value: "role"},
+				{Key: "db", Value: "admin"},
+				{Key: "roles", Value: bson.A{bson.D{{Key: "role", Value: "read"}, {Key: "db", Value: "admin"}}}},
+				{Key: "privileges", Value: bson.A{bson.D{
+					{Key: "resource", Value: bson.D{{Key: "db", Value: "admin"}, {Key: "collection", Value: "events"}}},
+					{Key: "actions", Value: bson.A{"find"}},
+				}}},
+			}
+			return bson.D{{Key: "roles", Value: bson.A{role}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	conf := fakeConfiguration(t, server)
+
+	state, err := applyResource(resourceDatabaseRole(), nil, map[string]interface{}{
+		"name":           "role",
+		"database":       "admin",
+		"privilege":      []interface{}{map[string]interface{}{"db": "admin", "collection": "events", "actions": []interface{}{"find"}}},
+		"inherited_role": []interface{}{map[string]interface{}{"role": "read", "db": "admin"}},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = applyResource(resourceDatabaseRole(), state, map[string]interface{}{
+		"name":           "role",
+		"database":       "admin",
+		"privilege":      []interface{}{map[string]interface{}{"db": "admin", "collection": "events", "actions": []interface{}{"find", "insert"}}},
+		"inherited_role": []interface{}{map[string]interface{}{"role": "readWrite", "db": "admin"}},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := server.received()
+	if contains(received, "dropRole") || len(server.sent("createRole")) != 1 {
+		t.Fatalf("expected the role to be updated in place, got %v", received)
+	}
+	if contains(received, "revokePrivilegesFromRole") || contains(received, "revokeRolesFromRole") {
+		t.Fatalf("expected updateRole to replace the privileges and roles at once, got %v", received)
+	}
+	updates := server.sent("updateRole")
+	if len(updates) != 2 {
+		t.Fatalf("expected an updateRole for the privileges and one for the inherited roles, got %v", received)
+	}
+	if privileges := updates[0].Lookup("privileges").String(); privileges != `[{"resource": {"db": "admin","collection": "events"},"actions": ["find","insert"]}]` {
+		t.Errorf("unexpected privileges %s", privileges)
+	}
+	if roles := updates[1].Lookup("roles").String(); roles != `[{"role": "readWrite","db": "admin"}]` {
+		t.Errorf("unexpected inherited roles %s", roles)
+	}
+}
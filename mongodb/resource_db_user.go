@@ -2,13 +2,15 @@ package mongodb
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
-	"strings"
+	"log"
+	"time"
 )
 
 func resourceDatabaseUser() *schema.Resource {
@@ -17,22 +19,85 @@ func resourceDatabaseUser() *schema.Resource {
 		ReadContext:   resourceDatabaseUserRead,
 		UpdateContext: resourceDatabaseUserUpdate,
 		DeleteContext: resourceDatabaseUserDelete,
+		CustomizeDiff: customdiff.Sequence(validateDatabaseUserDiff, passwordFileDiff, generatedPasswordDiff),
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importResourceId,
+		},
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Type:    resourceDatabaseUserV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: resourceIdStateUpgradeV0,
+				Version: 0,
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
 		},
 		Schema: map[string]*schema.Schema{
 			"auth_database": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"name":{
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"password":{
-				Type:     schema.TypeString,
-				Required: true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				ConflictsWith: []string{"password_file", "generate_password"},
+			},
+			"password_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"password", "generate_password"},
+			},
+			"password_hash": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"generate_password": generatePasswordSchema(),
+			"generated_password": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"verify_password": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"mechanisms": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateDiagFunc(validation.StringInSlice(scramMechanisms, false)),
+				},
+			},
+			"digest_password": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
 			},
+			"custom_data": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: validateDiagFunc(validation.StringIsJSON),
+				StateFunc: func(v interface{}) string {
+					return normalizeCustomData(v.(string))
+				},
+			},
+			"authentication_restriction": authenticationRestrictionSchema(),
 			"role": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -56,27 +121,59 @@ func resourceDatabaseUser() *schema.Resource {
 
 
 
+// validateDatabaseUserDiff rejects at plan time the passwords and mechanisms of the users of $external,
+// their invalid distinguished names and the users of other databases created without a password.
+func validateDatabaseUserDiff(ctx context.Context, diff *schema.ResourceDiff, i interface{}) error {
+	if !diff.NewValueKnown("auth_database") {
+		return nil
+	}
+	database := diff.Get("auth_database").(string)
+	passwordKeys := []string{"password", "password_file", "generate_password"}
+
+	if database != externalDatabase {
+		if diff.Id() != "" {
+			return nil
+		}
+		for _, key := range passwordKeys {
+			if _, ok := diff.GetOk(key); ok || !diff.NewValueKnown(key) {
+				return nil
+			}
+		}
+		return fmt.Errorf("password is required for the users of %s", database)
+	}
+
+	for _, key := range passwordKeys {
+		if _, ok := diff.GetOk(key); ok {
+			return fmt.Errorf("the users of %s authenticate with X.509 certificates or LDAP and cannot have a password", externalDatabase)
+		}
+	}
+	if _, ok := diff.GetOk("mechanisms"); (ok && diff.Id() == "") || diff.HasChange("mechanisms") {
+		return fmt.Errorf("the users of %s cannot have mechanisms", externalDatabase)
+	}
+	if !diff.Get("digest_password").(bool) {
+		return fmt.Errorf("the users of %s cannot have digest_password", externalDatabase)
+	}
+	if diff.NewValueKnown("name") {
+		return validateExternalUserName(diff.Get("name").(string))
+	}
+	return nil
+}
+
 func resourceDatabaseUserDelete(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	var stateId = data.State().ID
-	var database = data.Get("auth_database").(string)
-
-	id, errEncoding := base64.StdEncoding.DecodeString(stateId)
-	if errEncoding != nil {
-		return diag.Errorf("ID mismatch %s", errEncoding)
+	userName, database, err := parseResourceId(stateId)
+	if err != nil {
+		return diag.Errorf("%s", err)
 	}
 
-	// StateID is a concatenation of database and username. We only use the username here.
-	splitId := strings.Split(string(id), ".")
-	userName := splitId[1]
-
 	adminDB := client.Database(database)
 
-	result := adminDB.RunCommand(context.Background(), bson.D{{Key: "dropUser", Value: userName}})
+	result := adminDB.RunCommand(ctx, withWriteConcern(adminDB, bson.D{{Key: "dropUser", Value: userName}}))
 	if result.Err() != nil {
 		return diag.Errorf("%s",result.Err())
 	}
@@ -86,64 +183,145 @@ func resourceDatabaseUserDelete(ctx context.Context, data *schema.ResourceData,
 
 func resourceDatabaseUserUpdate(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	var stateId = data.State().ID
-	_, errEncoding := base64.StdEncoding.DecodeString(stateId)
-	if errEncoding != nil {
-		return diag.Errorf("ID mismatch %s", errEncoding)
+	userName, database, err := parseResourceId(stateId)
+	if err != nil {
+		return diag.Errorf("%s", err)
 	}
 
-	var userName = data.Get("name").(string)
-	var database = data.Get("auth_database").(string)
-	var userPassword = data.Get("password").(string)
-	
-	adminDB := client.Database(database)
+	mechanisms := expandStringList(data.Get("mechanisms").(*schema.Set).List())
+	digestPassword := data.Get("digest_password").(bool)
+	err = validatePasswordDigest(digestPassword, mechanisms)
+	if err != nil {
+		return diag.Errorf("%s", err)
+	}
+	if generatePasswordSettings(data) != nil && !digestPassword {
+		return diag.Errorf("generate_password cannot be used with digest_password = false")
+	}
 
-	result := adminDB.RunCommand(context.Background(), bson.D{{Key: "dropUser", Value: userName}})
-	if result.Err() != nil {
-		return diag.Errorf("%s",result.Err())
+	password, passwordKnown, err := configuredPassword(data)
+	if err != nil {
+		return diag.Errorf("%s", err)
 	}
-	var roleList []Role
-	var user = DbUser{
-		Name:     userName,
-		Password: userPassword,
+	passwordChanged := data.HasChanges("password", "generate_password")
+	passwordFile := data.Get("password_file").(string)
+	if passwordFile != "" {
+		passwordChanged = passwordFileChanged(data, password)
 	}
-	roles := data.Get("role").(*schema.Set).List()
-	roleMapErr := mapstructure.Decode(roles, &roleList)
-	if roleMapErr != nil {
-		return diag.Errorf("Error decoding map : %s ", roleMapErr)
+	// the password is generated again whenever the generate_password block or its keepers change
+	if settings := generatePasswordSettings(data); settings != nil && data.HasChange("generate_password") {
+		password, err = generatePassword(settings)
+		if err != nil {
+			return diag.Errorf("%s", err)
+		}
+		passwordKnown = true
+	}
+	if database == externalDatabase && (passwordKnown || data.HasChanges("mechanisms", "digest_password")) {
+		return diag.Errorf("the users of %s cannot have a password or mechanisms", externalDatabase)
+	}
+
+	var changes bson.D
+	// the password is sent again with the mechanisms, the server only accepts a subset of the current
+	// mechanisms without it. A password removed from the configuration is left as it is.
+	if (passwordChanged || data.HasChanges("mechanisms", "digest_password")) && passwordKnown {
+		changes = append(changes, bson.E{Key: "pwd", Value: password})
+		if !digestPassword {
+			changes = append(changes, bson.E{Key: "digestPassword", Value: false})
+		}
+	}
+	if data.HasChange("mechanisms") && len(mechanisms) != 0 {
+		changes = append(changes, bson.E{Key: "mechanisms", Value: mechanisms})
+	}
+	if data.HasChange("custom_data") {
+		customData, err := customDataDocument(data.Get("custom_data").(string))
+		if err != nil {
+			return diag.Errorf("%s", err)
+		}
+		changes = append(changes, bson.E{Key: "customData", Value: customData})
 	}
-	err2 := createUser(client,user,roleList,database)
-	if err2 != nil {
-		return diag.Errorf("Could not create the user : %s ", err2)
+	if data.HasChange("authentication_restriction") {
+		restrictions := expandAuthenticationRestrictions(data.Get("authentication_restriction").([]interface{}))
+		changes = append(changes, bson.E{Key: "authenticationRestrictions", Value: restrictions})
+	}
+	err = updateUser(ctx, client, userName, changes, database)
+	if err != nil {
+		return diag.Errorf("Could not update the user : %s ", err)
+	}
+	if passwordFile != "" {
+		hash, _ := data.GetChange("password_hash")
+		if passwordChanged {
+			hash, err = hashPassword(password)
+			if err != nil {
+				return diag.Errorf("%s", err)
+			}
+		}
+		err = data.Set("password_hash", hash)
+		if err != nil {
+			return diag.Errorf("error setting password_hash : %s ", err)
+		}
+	}
+	if generatePasswordSettings(data) != nil && data.HasChange("generate_password") {
+		err = data.Set("generated_password", password)
+		if err != nil {
+			return diag.Errorf("error setting generated_password : %s ", err)
+		}
+	}
+
+	if data.HasChange("role") {
+		oldRoles, newRoles := data.GetChange("role")
+		var grantList []Role
+		var revokeList []Role
+		grantMapErr := mapstructure.Decode(newRoles.(*schema.Set).Difference(oldRoles.(*schema.Set)).List(), &grantList)
+		if grantMapErr != nil {
+			return diag.Errorf("Error decoding map : %s ", grantMapErr)
+		}
+		revokeMapErr := mapstructure.Decode(oldRoles.(*schema.Set).Difference(newRoles.(*schema.Set)).List(), &revokeList)
+		if revokeMapErr != nil {
+			return diag.Errorf("Error decoding map : %s ", revokeMapErr)
+		}
+		// grant first so swapping one role for another never leaves the user without privileges
+		err := grantRolesToUser(ctx, client, userName, grantList, database)
+		if err != nil {
+			return diag.Errorf("Could not grant roles to the user : %s ", err)
+		}
+		err = revokeRolesFromUser(ctx, client, userName, revokeList, database)
+		if err != nil {
+			return diag.Errorf("Could not revoke roles from the user : %s ", err)
+		}
 	}
 
-	newId := database+"."+userName
-	encoded := base64.StdEncoding.EncodeToString([]byte(newId))
-	data.SetId(encoded)
-	return resourceDatabaseUserRead(ctx, data, i)
+	return readDatabaseUser(ctx, data, i, true)
 }
 
 func resourceDatabaseUserRead(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
+	return readDatabaseUser(ctx, data, i, false)
+}
+
+// readDatabaseUser reads the user with the provider read preference, or from the primary after a write so a
+// lagging secondary does not drop it from the state.
+func readDatabaseUser(ctx context.Context, data *schema.ResourceData, i interface{}, fromPrimary bool) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	stateID := data.State().ID
-	username, database , err := resourceDatabaseUserParseId(stateID)
+	username, database , err := parseResourceId(stateID)
 	if err != nil {
 		return diag.Errorf("%s",err)
 	}
-	result , decodeError := getUser(client,username,database)
+	result , decodeError := getUser(ctx, client,username,database,fromPrimary)
 	if decodeError != nil {
-		return diag.Errorf("Error decoding user : %s ", err)
+		return diag.Errorf("Error decoding user : %s ", decodeError)
 	}
 	if len(result.Users) == 0 {
-		return diag.Errorf("user does not exist")
+		log.Printf("[WARN] user %s does not exist in %s, removing it from state", username, database)
+		data.SetId("")
+		return nil
 	}
 	roles := make([]interface{}, len(result.Users[0].Roles))
 
@@ -157,13 +335,76 @@ func resourceDatabaseUserRead(ctx context.Context, data *schema.ResourceData, i
 	if dataSetError != nil  {
 		return diag.Errorf("error setting role : %s " , dataSetError)
 	}
+	customData, customDataError := customDataString(result.Users[0].CustomData)
+	if customDataError != nil {
+		return diag.Errorf("error decoding custom data : %s ", customDataError)
+	}
+	dataSetError = data.Set("custom_data", customData)
+	if dataSetError != nil  {
+		return diag.Errorf("error setting custom_data : %s " , dataSetError)
+	}
+	restrictions, restrictionsError := decodeAuthenticationRestrictions(result.Users[0].AuthenticationRestrictions)
+	if restrictionsError != nil {
+		return diag.Errorf("error decoding authentication restrictions : %s ", restrictionsError)
+	}
+	dataSetError = data.Set("authentication_restriction", flattenAuthenticationRestrictions(restrictions))
+	if dataSetError != nil  {
+		return diag.Errorf("error setting authentication_restriction : %s " , dataSetError)
+	}
+	dataSetError = data.Set("mechanisms", result.Users[0].Mechanisms)
+	if dataSetError != nil  {
+		return diag.Errorf("error setting mechanisms : %s " , dataSetError)
+	}
+	dataSetError = data.Set("name", username)
+	if dataSetError != nil  {
+		return diag.Errorf("error setting name : %s " , dataSetError)
+	}
 	dataSetError = data.Set("auth_database", database)
 	if dataSetError != nil  {
 		return diag.Errorf("error setting auth_db : %s " , dataSetError)
 	}
-	dataSetError = data.Set("password", data.Get("password"))
-	if dataSetError != nil  {
-		return diag.Errorf("error setting password : %s " , dataSetError)
+	password := data.Get("password").(string)
+	generated := generatePasswordSettings(data) != nil
+	if generated {
+		password = data.Get("generated_password").(string)
+	}
+	passwordFile := data.Get("password_file").(string)
+	if passwordFile != "" && data.Get("verify_password").(bool) {
+		password, err = readPasswordFile(passwordFile)
+		if err != nil {
+			return diag.Errorf("%s", err)
+		}
+	}
+	// a password changed outside of terraform is removed from state, so the next apply sets it again,
+	// a generated one is rotated and the hash of password_file is cleared
+	verifiable := password != "" && database != externalDatabase && data.Get("digest_password").(bool)
+	if data.Get("verify_password").(bool) && verifiable {
+		verified, verifyError := config.verifyUserPassword(ctx, username, password, database)
+		if verifyError != nil {
+			return diag.Errorf("error verifying the password of %s : %s ", username, verifyError)
+		}
+		if !verified {
+			log.Printf("[WARN] the password of user %s in %s was changed outside of terraform", username, database)
+			if generated {
+				dataSetError = data.Set("generate_password", nil)
+				if dataSetError != nil  {
+					return diag.Errorf("error setting generate_password : %s " , dataSetError)
+				}
+			}
+			if passwordFile != "" {
+				dataSetError = data.Set("password_hash", "")
+				if dataSetError != nil  {
+					return diag.Errorf("error setting password_hash : %s " , dataSetError)
+				}
+			}
+			password = ""
+		}
+	}
+	if !generated && passwordFile == "" {
+		dataSetError = data.Set("password", password)
+		if dataSetError != nil  {
+			return diag.Errorf("error setting password : %s " , dataSetError)
+		}
 	}
 	data.SetId(stateID)
 	return nil
@@ -171,46 +412,107 @@ func resourceDatabaseUserRead(ctx context.Context, data *schema.ResourceData, i
 
 func resourceDatabaseUserCreate(ctx context.Context, data *schema.ResourceData, i interface{}) diag.Diagnostics {
 	var config = i.(*MongoDatabaseConfiguration)
-	client , connectionError := MongoClientInit(config)
+	client , connectionError := MongoClientInit(ctx, config)
 	if connectionError != nil {
 		return diag.Errorf("Error connecting to database : %s ", connectionError)
 	}
 	var database = data.Get("auth_database").(string)
 	var userName = data.Get("name").(string)
-	var userPassword = data.Get("password").(string)
+	var userPassword, _, err = configuredPassword(data)
+	if err != nil {
+		return diag.Errorf("%s", err)
+	}
+	var generateSettings = generatePasswordSettings(data)
+	if generateSettings != nil {
+		generated, err := generatePassword(generateSettings)
+		if err != nil {
+			return diag.Errorf("%s", err)
+		}
+		userPassword = generated
+	}
 	var roleList []Role
 	var user = DbUser{
-		Name:     userName,
-		Password: userPassword,
+		Name:                       userName,
+		Password:                   userPassword,
+		CustomData:                 data.Get("custom_data").(string),
+		AuthenticationRestrictions: expandAuthenticationRestrictions(data.Get("authentication_restriction").([]interface{})),
+		Mechanisms:                 expandStringList(data.Get("mechanisms").(*schema.Set).List()),
+		DigestPassword:             data.Get("digest_password").(bool),
+	}
+	err = validateUserPassword(database, userName, userPassword)
+	if err != nil {
+		return diag.Errorf("%s", err)
+	}
+	if database == externalDatabase && len(user.Mechanisms) != 0 {
+		return diag.Errorf("the users of %s cannot have mechanisms", externalDatabase)
+	}
+	err = validatePasswordDigest(user.DigestPassword, user.Mechanisms)
+	if err != nil {
+		return diag.Errorf("%s", err)
+	}
+	if generateSettings != nil && !user.DigestPassword {
+		return diag.Errorf("generate_password cannot be used with digest_password = false")
 	}
 	roles := data.Get("role").(*schema.Set).List()
 	roleMapErr := mapstructure.Decode(roles, &roleList)
 	if roleMapErr != nil {
 		return diag.Errorf("Error decoding map : %s ", roleMapErr)
 	}
-	err := createUser(client,user,roleList,database)
+	err = createUser(ctx, client,user,roleList,database)
 	if err != nil {
 		return diag.Errorf("Could not create the user : %s ", err)
 	}
-	str := database+"."+userName
-	encoded := base64.StdEncoding.EncodeToString([]byte(str))
-	data.SetId(encoded)
-	return resourceDatabaseUserRead(ctx, data, i)
-}
-
-func resourceDatabaseUserParseId(id string) (string, string, error){
-	result , errEncoding := base64.StdEncoding.DecodeString(id)
-
-	if errEncoding != nil {
-		return "", "", fmt.Errorf("unexpected format of ID Error : %s", errEncoding)
+	if generateSettings != nil {
+		err = data.Set("generated_password", userPassword)
+		if err != nil {
+			return diag.Errorf("error setting generated_password : %s ", err)
+		}
 	}
-	parts := strings.SplitN(string(result), ".", 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", fmt.Errorf("unexpected format of ID (%s), expected attribute1.attribute2", id)
+	if data.Get("password_file").(string) != "" {
+		hash, err := hashPassword(userPassword)
+		if err != nil {
+			return diag.Errorf("%s", err)
+		}
+		err = data.Set("password_hash", hash)
+		if err != nil {
+			return diag.Errorf("error setting password_hash : %s ", err)
+		}
 	}
+	data.SetId(formatResourceId(database, userName))
+	return readDatabaseUser(ctx, data, i, true)
+}
 
-	database := parts[0]
-	userName := parts[1]
-
-	return userName , database , nil
+func resourceDatabaseUserV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"auth_database": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"password": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"role": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"db": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"role": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+		},
+	}
 }
@@ -0,0 +1,283 @@
+package mongodb
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResourceDatabaseUserReadNotFound(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	data := schema.TestResourceDataRaw(t, resourceDatabaseUser().Schema, map[string]interface{}{"name": "user", "auth_database": "admin"})
+	data.SetId(formatResourceId("admin", "user"))
+
+	if diags := resourceDatabaseUserRead(context.Background(), data, fakeConfiguration(t, server)); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if data.Id() != "" {
+		t.Errorf("expected a deleted user to be removed from state, got id %s", data.Id())
+	}
+}
+
+func TestResourceDatabaseUserReadError(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		return commandError(13, "Unauthorized", "not authorized on admin to execute command"), command == "usersInfo"
+	})
+	data := schema.TestResourceDataRaw(t, resourceDatabaseUser().Schema, map[string]interface{}{"name": "user", "auth_database": "admin"})
+	data.SetId(formatResourceId("admin", "user"))
+
+	if diags := resourceDatabaseUserRead(context.Background(), data, fakeConfiguration(t, server)); !diags.HasError() {
+		t.Fatal("expected an authorization failure to be reported")
+	}
+	if data.Id() != formatResourceId("admin", "user") {
+		t.Errorf("expected the user to stay in state, got id %q", data.Id())
+	}
+}
+
+func TestResourceDatabaseUserReadUnreachable(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	_ = server.listener.Close()
+	conf := fakeConfiguration(t, server)
+	conf.Config.ServerSelectionTimeout = 500 * time.Millisecond
+	data := schema.TestResourceDataRaw(t, resourceDatabaseUser().Schema, map[string]interface{}{"name": "user", "auth_database": "admin"})
+	data.SetId(formatResourceId("admin", "user"))
+
+	if diags := resourceDatabaseUserRead(context.Background(), data, conf); !diags.HasError() {
+		t.Fatal("expected an unreachable server to be reported")
+	}
+	if data.Id() != formatResourceId("admin", "user") {
+		t.Errorf("expected the user to stay in state, got id %q", data.Id())
+	}
+}
+
+// planDatabaseUser diffs the configuration against state as terraform plan does, a nil state creates the user.
+func planDatabaseUser(state *terraform.InstanceState, raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+	return resourceDatabaseUser().Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), nil)
+}
+
+func TestResourceDatabaseUserPlanValidation(t *testing.T) {
+	passwordFile := writePasswordFile(t, "secret")
+	tests := []struct {
+		name  string
+		raw   map[string]interface{}
+		valid bool
+	}{
+		{"password", map[string]interface{}{"auth_database": "admin", "name": "user", "password": "secret"}, true},
+		{"password_file", map[string]interface{}{"auth_database": "admin", "name": "user", "password_file": passwordFile}, true},
+		{"unreadable password_file", map[string]interface{}{"auth_database": "admin", "name": "user", "password_file": passwordFile + ".missing"}, false},
+		{"generate_password", map[string]interface{}{"auth_database": "admin", "name": "user", "generate_password": []interface{}{map[string]interface{}{"length": 24}}}, true},
+		{"missing password", map[string]interface{}{"auth_database": "admin", "name": "user"}, false},
+		{"external", map[string]interface{}{"auth_database": "$external", "name": "CN=client,OU=clients,O=example"}, true},
+		{"external ldap", map[string]interface{}{"auth_database": "$external", "name": "ldap-user"}, true},
+		{"external password", map[string]interface{}{"auth_database": "$external", "name": "CN=client", "password": "secret"}, false},
+		{"external password_file", map[string]interface{}{"auth_database": "$external", "name": "CN=client", "password_file": passwordFile}, false},
+		{"external mechanisms", map[string]interface{}{"auth_database": "$external", "name": "CN=client", "mechanisms": []interface{}{"SCRAM-SHA-256"}}, false},
+		{"external digest_password", map[string]interface{}{"auth_database": "$external", "name": "CN=client", "digest_password": false}, false},
+		{"external invalid distinguished name", map[string]interface{}{"auth_database": "$external", "name": "CN=client,OU="}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := planDatabaseUser(nil, test.raw)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the plan to fail")
+			}
+		})
+	}
+}
+
+func TestResourceDatabaseUserCreateReadsFromPrimary(t *testing.T) {
+	var lock sync.Mutex
+	var modes []string
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createUser":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "usersInfo":
+			mode := readPreferenceMode(body)
+			lock.Lock()
+			modes = append(modes, mode)
+			lock.Unlock()
+			// the secondary has not replicated the user yet
+			if mode == "secondary" {
+				return nil, false
+			}
+			user := bson.D{{Key: "user", Value: "user"}, {Key: "db", Value: "admin"}, {Key: "roles", Value: bson.A{}}, {Key: "mechanisms", Value: bson.A{"SCRAM-SHA-256"}}}
+			return bson.D{{Key: "users", Value: bson.A{user}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	conf := laggingConfiguration(t, server)
+	data := schema.TestResourceDataRaw(t, resourceDatabaseUser().Schema, map[string]interface{}{"name": "user", "auth_database": "admin", "password": "secret"})
+
+	if diags := resourceDatabaseUserCreate(context.Background(), data, conf); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if data.Id() != formatResourceId("admin", "user") {
+		t.Fatalf("expected the created user to stay in state, got id %q", data.Id())
+	}
+
+	if diags := resourceDatabaseUserRead(context.Background(), data, conf); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(modes) != 2 || modes[0] != "primary" || modes[1] != "secondary" {
+		t.Errorf("expected the read back on the primary and the refresh on a secondary, got %v", modes)
+	}
+}
+
+func TestResourceDatabaseUserUpdate(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createUser", "updateUser", "grantRolesToUser", "revokeRolesFromUser":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "usersInfo":
+			user := bson.D{
+				{Key: "user", Value: "user"},
+				{Key: "db", Value: "admin"},
+				{Key: "roles", Value: bson.A{bson.D{{Key: "role", Value: "read"}, {Key: "db", Value: "admin"}}}},
+				{Key: "mechanisms", Value: bson.A{"SCRAM-SHA-1", "SCRAM-SHA-256"}},
+			}
+			return bson.D{{Key: "users", Value: bson.A{user}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	conf := fakeConfiguration(t, server)
+
+	state, err := applyResource(resourceDatabaseUser(), nil, map[string]interface{}{
+		"name":          "user",
+		"auth_database": "admin",
+		"password":      "secret",
+		"mechanisms":    []interface{}{"SCRAM-SHA-1", "SCRAM-SHA-256"},
+		"role":          []interface{}{map[string]interface{}{"role": "read", "db": "admin"}},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = applyResource(resourceDatabaseUser(), state, map[string]interface{}{
+		"name":          "user",
+		"auth_database": "admin",
+		"password":      "secret",
+		"mechanisms":    []interface{}{"SCRAM-SHA-256"},
+		"custom_data":   `{"team":"data"}`,
+		"role":          []interface{}{map[string]interface{}{"role": "readWrite", "db": "admin"}},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := server.received()
+	if contains(received, "dropUser") || len(server.sent("createUser")) != 1 {
+		t.Fatalf("expected the user to be updated in place, got %v", received)
+	}
+	updates := server.sent("updateUser")
+	if len(updates) != 1 {
+		t.Fatalf("expected a single updateUser, got %v", received)
+	}
+	var update struct {
+		Pwd        string   `bson:"pwd"`
+		Mechanisms []string `bson:"mechanisms"`
+		CustomData bson.M   `bson:"customData"`
+	}
+	if err := bson.Unmarshal(updates[0], &update); err != nil {
+		t.Fatal(err)
+	}
+	// the server only accepts a subset of the mechanisms with the password
+	if update.Pwd != "secret" || len(update.Mechanisms) != 1 || update.Mechanisms[0] != "SCRAM-SHA-256" || update.CustomData["team"] != "data" {
+		t.Errorf("unexpected updateUser %s", updates[0])
+	}
+
+	var grant, revoke int
+	for i, command := range received {
+		switch command {
+		case "grantRolesToUser":
+			grant = i
+		case "revokeRolesFromUser":
+			revoke = i
+		}
+	}
+	if grant == 0 || revoke == 0 || grant > revoke {
+		t.Fatalf("expected the new roles to be granted before the old ones are revoked, got %v", received)
+	}
+	if roles := server.sent("grantRolesToUser")[0].Lookup("roles").String(); roles != `[{"role": "readWrite","db": "admin"}]` {
+		t.Errorf("unexpected granted roles %s", roles)
+	}
+	if roles := server.sent("revokeRolesFromUser")[0].Lookup("roles").String(); roles != `[{"role": "read","db": "admin"}]` {
+		t.Errorf("unexpected revoked roles %s", roles)
+	}
+}
+
+func TestResourceDatabaseUserEmptyCustomData(t *testing.T) {
+	server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+		switch command {
+		case "createUser":
+			return bson.D{{Key: "ok", Value: 1}}, true
+		case "usersInfo":
+			user := bson.D{{Key: "user", Value: "user"}, {Key: "db", Value: "admin"}, {Key: "roles", Value: bson.A{}}, {Key: "customData", Value: bson.D{}}}
+			return bson.D{{Key: "users", Value: bson.A{user}}, {Key: "ok", Value: 1}}, true
+		}
+		return nil, false
+	})
+	raw := map[string]interface{}{"name": "user", "auth_database": "admin", "password": "secret", "custom_data": "{ }"}
+
+	state, err := applyResource(resourceDatabaseUser(), nil, raw, fakeConfiguration(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := planDatabaseUser(state, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected an empty custom_data to match the user read back, got %#v", diff.Attributes)
+	}
+}
+
+func TestResourceDatabaseUserReadVerifyPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    bson.D
+		password string
+		fails    bool
+	}{
+		{"password changed", commandError(18, "AuthenticationFailed", "Authentication failed."), "", false},
+		{"unauthorized", commandError(13, "Unauthorized", "not authorized"), "secret", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, nil, func(command string, database string, body bson.Raw) (bson.D, bool) {
+				switch command {
+				case "saslStart":
+					return test.reply, true
+				case "usersInfo":
+					user := bson.D{{Key: "user", Value: "user"}, {Key: "db", Value: "admin"}, {Key: "roles", Value: bson.A{}}, {Key: "mechanisms", Value: bson.A{"SCRAM-SHA-1"}}}
+					return bson.D{{Key: "users", Value: bson.A{user}}, {Key: "ok", Value: 1}}, true
+				}
+				return nil, false
+			})
+			data := schema.TestResourceDataRaw(t, resourceDatabaseUser().Schema, map[string]interface{}{"name": "user", "auth_database": "admin", "password": "secret", "verify_password": true})
+			data.SetId(formatResourceId("admin", "user"))
+
+			diags := resourceDatabaseUserRead(context.Background(), data, fakeConfiguration(t, server))
+			if diags.HasError() != test.fails {
+				t.Fatalf("unexpected diagnostics: %v", diags)
+			}
+			if !contains(server.received(), "saslStart") {
+				t.Fatalf("expected the password to be verified, got %v", server.received())
+			}
+			if password := data.Get("password").(string); password != test.password {
+				t.Errorf("expected password %q in state, got %q", test.password, password)
+			}
+			if data.Id() != formatResourceId("admin", "user") {
+				t.Errorf("expected the user to stay in state, got id %q", data.Id())
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+	"unicode/utf8"
+)
+
+// Users and roles are identified by their database and name, written database.name.
+// Database names can neither contain "." nor "/", so the first of them always ends the database
+// and names containing dots are preserved.
+
+func formatResourceId(database string, name string) string {
+	return database + "." + name
+}
+
+// parseResourceId accepts database.name and database/name.
+func parseResourceId(id string) (string, string, error) {
+	separator := strings.IndexAny(id, "./")
+	if separator <= 0 || separator == len(id)-1 {
+		return "", "", fmt.Errorf("unexpected format of ID (%s), expected database.name or database/name", id)
+	}
+
+	database := id[:separator]
+	name := id[separator+1:]
+
+	return name, database, nil
+}
+
+func decodeLegacyResourceId(id string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || !utf8.Valid(decoded) {
+		return "", false
+	}
+	separator := strings.Index(string(decoded), ".")
+	if separator <= 0 || separator == len(decoded)-1 {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+func importResourceId(ctx context.Context, data *schema.ResourceData, i interface{}) ([]*schema.ResourceData, error) {
+	name, database, err := parseResourceId(data.Id())
+	if err != nil {
+		return nil, err
+	}
+	data.SetId(formatResourceId(database, name))
+	return []*schema.ResourceData{data}, nil
+}
+
+// resourceIdStateUpgradeV0 moves the base64 encoded ids of schema version 0 to database.name.
+func resourceIdStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, i interface{}) (map[string]interface{}, error) {
+	id, ok := rawState["id"].(string)
+	if !ok || id == "" {
+		return rawState, nil
+	}
+	if legacy, ok := decodeLegacyResourceId(id); ok {
+		id = legacy
+	}
+	name, database, err := parseResourceId(id)
+	if err != nil {
+		return nil, err
+	}
+	rawState["id"] = formatResourceId(database, name)
+	return rawState, nil
+}
@@ -0,0 +1,59 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseResourceId(t *testing.T) {
+	tests := []struct {
+		id       string
+		name     string
+		database string
+	}{
+		{"admin.user", "user", "admin"},
+		{"admin/user", "user", "admin"},
+		{"admin.first.last", "first.last", "admin"},
+		{"admin/first.last", "first.last", "admin"},
+		{"$external.CN=user,OU=Clients", "CN=user,OU=Clients", "$external"},
+	}
+	for _, test := range tests {
+		name, database, err := parseResourceId(test.id)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.id, err)
+			continue
+		}
+		if name != test.name || database != test.database {
+			t.Errorf("%s: got name %q and database %q", test.id, name, database)
+		}
+	}
+
+	for _, id := range []string{"", "user", ".user", "admin.", "admin/", base64.StdEncoding.EncodeToString([]byte("admin.user"))} {
+		if _, _, err := parseResourceId(id); err == nil {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+}
+
+func TestResourceIdStateUpgradeV0(t *testing.T) {
+	tests := map[string]string{
+		base64.StdEncoding.EncodeToString([]byte("admin.user")):       "admin.user",
+		base64.StdEncoding.EncodeToString([]byte("admin.first.last")): "admin.first.last",
+		"admin.user": "admin.user",
+	}
+	for id, expected := range tests {
+		state, err := resourceIdStateUpgradeV0(context.Background(), map[string]interface{}{"id": id, "name": "user"}, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", id, err)
+			continue
+		}
+		if state["id"] != expected || state["name"] != "user" {
+			t.Errorf("%s: upgraded to %v", id, state)
+		}
+	}
+
+	if _, err := resourceIdStateUpgradeV0(context.Background(), map[string]interface{}{"id": "invalid"}, nil); err == nil {
+		t.Error("expected an invalid id to be rejected")
+	}
+}
@@ -0,0 +1,276 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SSHTunnelConfig struct {
+	Host                  string
+	Port                  string
+	User                  string
+	PrivateKey            string
+	PrivateKeyPassphrase  string
+	UseAgent              bool
+	KnownHostsFile        string
+	HostKey               string
+	InsecureIgnoreHostKey bool
+}
+
+// sshKeepaliveTimeout is how long the bastion has to answer a keepalive before its connection is replaced.
+const sshKeepaliveTimeout = 10 * time.Second
+
+// sshTunnelDialer opens the driver connections as direct-tcpip channels of a single ssh connection to the bastion.
+type sshTunnelDialer struct {
+	config  *SSHTunnelConfig
+	forward options.ContextDialer
+
+	lock      sync.Mutex
+	client    *ssh.Client
+	agentConn net.Conn
+}
+
+func newSSHTunnelDialer(config *SSHTunnelConfig, forward options.ContextDialer) *sshTunnelDialer {
+	return &sshTunnelDialer{
+		config:  config,
+		forward: forward,
+	}
+}
+
+func (t *sshTunnelDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := t.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialChannel(ctx, client, network, address)
+	if err == nil {
+		return conn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	// most failures come from an unreachable target, the bastion connection shared by every other
+	// driver connection is only replaced once it stops answering
+	if t.alive(ctx, client) {
+		return nil, fmt.Errorf("could not reach %s through the ssh tunnel : %s", address, err)
+	}
+
+	t.reset(client)
+	client, err = t.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = dialChannel(ctx, client, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s through the ssh tunnel : %s", address, err)
+	}
+	return conn, nil
+}
+
+// dialChannel opens a direct-tcpip channel, ssh.Client.Dial does not take a context so it runs aside
+// and is abandoned once ctx is done.
+func dialChannel(ctx context.Context, client *ssh.Client, network, address string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		conn, err := client.Dial(network, address)
+		results <- dialResult{conn, err}
+	}()
+
+	select {
+	case result := <-results:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return withDeadlines(result.conn), nil
+	case <-ctx.Done():
+		go func() {
+			if result := <-results; result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// withDeadlines bridges an ssh channel, which rejects SetDeadline, through a pipe so the driver
+// can still bound its reads and writes.
+func withDeadlines(channel net.Conn) net.Conn {
+	local, remote := net.Pipe()
+	go func() {
+		_, _ = io.Copy(channel, remote)
+		_ = channel.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(remote, channel)
+		_ = remote.Close()
+	}()
+	return local
+}
+
+// alive sends a keepalive request on the ssh connection, it fails once the transport is dead.
+func (t *sshTunnelDialer) alive(ctx context.Context, client *ssh.Client) bool {
+	replies := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		replies <- err
+	}()
+
+	timer := time.NewTimer(sshKeepaliveTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-replies:
+		return err == nil
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return true
+	}
+}
+
+func (t *sshTunnelDialer) sshClient(ctx context.Context) (*ssh.Client, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	clientConfig, err := t.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(t.config.Host, t.config.Port)
+	conn, err := t.forward.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the ssh host %s : %s", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed : %s", address, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	t.client = ssh.NewClient(sshConn, channels, requests)
+	return t.client, nil
+}
+
+func (t *sshTunnelDialer) clientConfig() (*ssh.ClientConfig, error) {
+	var authMethods []ssh.AuthMethod
+
+	if t.config.PrivateKey != "" {
+		key, err := pemContent(t.config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if t.config.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(t.config.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the ssh private key : %s", err)
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+
+	if t.config.UseAgent {
+		if t.agentConn == nil {
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				return nil, errors.New("use_agent is enabled but SSH_AUTH_SOCK is not set")
+			}
+			agentConn, err := net.Dial("unix", socket)
+			if err != nil {
+				return nil, fmt.Errorf("could not connect to the ssh agent : %s", err)
+			}
+			t.agentConn = agentConn
+		}
+		authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(t.agentConn).Signers))
+	}
+
+	hostKeyCallback, err := t.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            t.config.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func (t *sshTunnelDialer) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if t.config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if t.config.HostKey != "" {
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.config.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the ssh host_key : %s", err)
+		}
+		return ssh.FixedHostKey(hostKey), nil
+	}
+
+	knownHostsFile := t.config.KnownHostsFile
+	if strings.HasPrefix(knownHostsFile, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, knownHostsFile[2:])
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the ssh known_hosts_file %s : %s", knownHostsFile, err)
+	}
+	return callback, nil
+}
+
+func (t *sshTunnelDialer) reset(client *ssh.Client) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.client == client {
+		_ = t.client.Close()
+		t.client = nil
+	}
+}
+
+// Close closes the ssh connection to the bastion and the ssh agent connection.
+func (t *sshTunnelDialer) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var err error
+	if t.client != nil {
+		err = t.client.Close()
+		t.client = nil
+	}
+	if t.agentConn != nil {
+		_ = t.agentConn.Close()
+		t.agentConn = nil
+	}
+	return err
+}
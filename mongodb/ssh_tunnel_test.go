@@ -0,0 +1,282 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blackholeHost is a direct-tcpip target the ssh server never answers for.
+const blackholeHost = "blackhole.invalid"
+
+// sshServer is an in-process bastion forwarding direct-tcpip channels, it only accepts clientKey.
+type sshServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+
+	lock        sync.Mutex
+	connections []*ssh.ServerConn
+}
+
+func generateSSHKey(t *testing.T) (ssh.Signer, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) *sshServer {
+	hostKey, _ := generateSSHKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, errors.New("unknown public key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &sshServer{listener: listener, config: config, hostKey: hostKey}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		server.drop()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *sshServer) serve(conn net.Conn) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	s.lock.Lock()
+	s.connections = append(s.connections, sshConn)
+	s.lock.Unlock()
+
+	// keepalives are answered with a failure, like openssh does for unknown global requests
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+			continue
+		}
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		if target.Host == blackholeHost {
+			continue
+		}
+		go forwardChannel(newChannel, net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	}
+}
+
+func forwardChannel(newChannel ssh.NewChannel, address string) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(channel, conn)
+		_ = channel.Close()
+	}()
+	_, _ = io.Copy(conn, channel)
+	_ = conn.Close()
+}
+
+func (s *sshServer) connectionCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.connections)
+}
+
+// drop closes the ssh connections, as a bastion restart would.
+func (s *sshServer) drop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.connections {
+		_ = conn.Close()
+	}
+}
+
+func (s *sshServer) tunnelConfig(t *testing.T, privateKey string) *SSHTunnelConfig {
+	host, port, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SSHTunnelConfig{
+		Host:       host,
+		Port:       port,
+		User:       "tunnel",
+		PrivateKey: privateKey,
+		HostKey:    string(ssh.MarshalAuthorizedKey(s.hostKey.PublicKey())),
+	}
+}
+
+func startTunnel(t *testing.T) (*sshServer, *sshTunnelDialer) {
+	clientKey, privateKey := generateSSHKey(t)
+	bastion := startSSHServer(t, clientKey.PublicKey())
+	tunnel := newSSHTunnelDialer(bastion.tunnelConfig(t, privateKey), &net.Dialer{})
+	t.Cleanup(func() { _ = tunnel.Close() })
+	return bastion, tunnel
+}
+
+func TestSSHTunnelMongoClient(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	clientKey, privateKey := generateSSHKey(t)
+	bastion := startSSHServer(t, clientKey.PublicKey())
+
+	config := fakeClientConfig(server)
+	config.SSHTunnel = bastion.tunnelConfig(t, privateKey)
+	conf := &MongoDatabaseConfiguration{Config: config, MaxConnLifetime: 10 * time.Second}
+	defer conf.Disconnect(context.Background())
+
+	client, err := MongoClientInit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getUser(context.Background(), client, "user", "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	if !contains(server.received(), "usersInfo") {
+		t.Errorf("expected usersInfo to reach the server through the tunnel, got %v", server.received())
+	}
+	if count := bastion.connectionCount(); count != 1 {
+		t.Errorf("expected the driver connections to share one ssh connection, got %d", count)
+	}
+}
+
+func TestSSHTunnelUnreachableTargetKeepsConnection(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	bastion, tunnel := startTunnel(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	_ = closed.Close()
+
+	if _, err := tunnel.DialContext(context.Background(), "tcp", unreachable); err == nil {
+		t.Fatal("expected the dial of a closed port to fail")
+	}
+	conn, err := tunnel.DialContext(context.Background(), "tcp", server.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if count := bastion.connectionCount(); count != 1 {
+		t.Errorf("expected an unreachable target to keep the ssh connection, got %d ssh connections", count)
+	}
+}
+
+func TestSSHTunnelReconnects(t *testing.T) {
+	server := startFakeServer(t, nil, nil)
+	bastion, tunnel := startTunnel(t)
+
+	conn, err := tunnel.DialContext(context.Background(), "tcp", server.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	bastion.drop()
+	conn, err = tunnel.DialContext(context.Background(), "tcp", server.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if count := bastion.connectionCount(); count != 2 {
+		t.Errorf("expected a dead ssh connection to be replaced, got %d ssh connections", count)
+	}
+}
+
+func TestSSHTunnelDialHonoursContext(t *testing.T) {
+	bastion, tunnel := startTunnel(t)
+	if _, err := tunnel.sshClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := tunnel.DialContext(ctx, "tcp", net.JoinHostPort(blackholeHost, "27017"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the dial to stop at the context deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the dial to return at the deadline, took %s", elapsed)
+	}
+	if count := bastion.connectionCount(); count != 1 {
+		t.Errorf("expected a cancelled dial to keep the ssh connection, got %d ssh connections", count)
+	}
+}
+
+func TestSSHTunnelHostKeyMismatch(t *testing.T) {
+	clientKey, privateKey := generateSSHKey(t)
+	bastion := startSSHServer(t, clientKey.PublicKey())
+	otherKey, _ := generateSSHKey(t)
+
+	config := bastion.tunnelConfig(t, privateKey)
+	config.HostKey = string(ssh.MarshalAuthorizedKey(otherKey.PublicKey()))
+	tunnel := newSSHTunnelDialer(config, &net.Dialer{})
+	defer tunnel.Close()
+
+	_, err := tunnel.DialContext(context.Background(), "tcp", "127.0.0.1:27017")
+	if err == nil || !strings.Contains(err.Error(), "handshake") {
+		t.Fatalf("expected the handshake to reject an unknown host key, got %v", err)
+	}
+}